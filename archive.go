@@ -1,8 +1,14 @@
 package main 
 
 import(
+  "context"
   "fmt"
+  "os"
+  "os/signal"
+  "strings"
+  "syscall"
   "time"
+  "craft-config/lib"
   "craft-config/version"
   // "github.com/Sirupsen/logrus"
 
@@ -11,166 +17,550 @@ import(
 )
 
 func doArchiveAndPublish(server *mclib.Server) {
+  os.Exit(runArchiveAndPublish(server))
+}
+
+// Returns the exit status.
+func runArchiveAndPublish(server *mclib.Server) (int) {
   retries := rconRetriesArg
   waitTime := time.Duration(rconDelayArg) * time.Second
 
   f := server.LogFields()
-  if retries < 0  {
-    count := 0
-    for {
-      server.NewRconWithRetry(10, waitTime)
-      if server.HasRconConnection() {break}
-      count++
-      f["retryOuterLoop"] = count
-      log.Debug(f,"Continuing to try to connect to rcon.")
-    }
-  } else {
-      server.NewRconWithRetry(retries, waitTime)
+  var err error
+  notifier, err = lib.NewNotifier(server, webhookArg, webhookSecretArg, webhookEventsArg)
+  if err != nil {
+    log.Fatal(f, "Controller starting up: Bad webhook configuration.", err)
+  }
+  if notifier != nil {
+    notifier.Retries = webhookRetriesArg
+    notifier.RetryDelay = webhookRetryDelayArg
+  }
+
+  // With retries < 0 this waits for as long as it takes.
+  serverRcon, err = connectRcon(server, retries, waitTime)
+  if err != nil {
+    log.Error(f, "Can't connect to RCON, snapshots won't pause saving.", err)
   }
 
+  status := exitOK
   if continuousArchiveArg {
-    continuousArchiveAndPublish(server)
+    status = continuousArchiveAndPublish(server)
+  } else if err := archiveAndPublish(context.Background(), server, mclib.ServerSnapshot); err != nil {
+    status = exitSnapshotFailed
+  }
+  if !notifier.Wait(webhookWaitTimeout) {
+    log.Error(f, "Gave up waiting for webhooks.", fmt.Errorf("Timed out after %s", webhookWaitTimeout))
+  }
+  if serverRcon != nil { serverRcon.Close() }
+  return status
+}
+
+// The server's RCON connection, nil when we don't have one. Once
+// continuous snapshots start it's only set holding rconInUse. After that
+// it's reconnected rather than replaced, a snapshot may still have it.
+var serverRcon *lib.RconClient
+
+// Try retries more times after the first, forever if retries < 0.
+func connectRcon(s *mclib.Server, retries int, wait time.Duration) (rcon *lib.RconClient, err error) {
+  f := s.LogFields()
+  f["operation"] = "RconConnect"
+  for attempt := 0; retries < 0 || attempt <= retries; attempt++ {
+    if attempt > 0 { time.Sleep(wait) }
+    if rcon, err = lib.NewRconClient(s.PublicServerIp, s.RconPort, s.RconPassword); err == nil {
+      return rcon, nil
+    }
+    f["attempt"] = attempt + 1
+    f["error"] = err.Error()
+    log.Debug(f, "Can't connect to RCON yet.")
+  }
+  return nil, err
+}
+
+// Reconnect a broken connection, one try.
+func reconnectRcon(s *mclib.Server) {
+  if serverRcon == nil {
+    serverRcon, _ = connectRcon(s, 0, 0)
   } else {
-    archiveAndPublish(server, mclib.ServerSnapshot)
+    ctx, cancel := context.WithTimeout(context.Background(), lib.DefaultRconTimeout)
+    serverRcon.Reconnect(ctx)
+    cancel()
+  }
+  metrics.rconReconnected()
+}
+
+// Count users unless a snapshot is pausing or resuming saving, reconnecting if that fails.
+func checkUsers(s *mclib.Server) (users int, busy bool, err error) {
+  select {
+  case rconInUse <- struct{}{}:
+  default:
+    return 0, true, nil
   }
+  users, err = numberOfUsers()
+  if err != nil { reconnectRcon(s) }
+  <-rconInUse
+  controller.usersChecked(users, err)
+  if err == nil { metrics.usersChecked(users) }
+  return users, false, err
 }
 
+func numberOfUsers() (int, error) {
+  if serverRcon == nil { return 0, fmt.Errorf("No RCON connection.") }
+  return serverRcon.NumberOfUsers()
+}
+
+// Where webhooks go, nil for none.
+var notifier *lib.Notifier
+
+// How long to wait for webhooks still being sent when we exit.
+const webhookWaitTimeout = 10 * time.Second
+
+// Exit status of the archive command.
+const(
+  exitOK = 0
+  exitSnapshotFailed = 1
+  exitStopTimeout = 2
+)
+
+// How long to wait between passes over the spool, doubling after each failed pass.
+const(
+  spoolRetryDelay = 30 * time.Second
+  spoolMaxRetryDelay = 30 * time.Minute
+)
+
 // TODO: Set up some asynchronous go routines:
 // DONE 1. Delay timer: every 5 mniutes or so, come along and do a backup if there are users (what we have now).
 // 2. File Watcher: check to see if non-world files have been created and update those.
 // DONE 3. User Watcher: assuming the file watcher, can't proxy for this. Set a timeout for every 10 seconds 
 // or so and check for new users, update when one shows up.
 //
-// Finally  Put the whole thing in a go-routine that checks for a stop (see the watcher in ineteractive.)
+// DONE Finally  Put the whole thing in a go-routine that checks for a stop (see the watcher in ineteractive.)
 
-// TODO: Probably time to make these both command line paramaters.
-const backupDelay = 5 * time.Minute
-const userCheckDelay = 30 * time.Second
 const(
   newUser = iota
   backupTimeout
+  scheduled
+  manual
 )
-func continuousArchiveAndPublish(s *mclib.Server) {
+
+// Held by snapshots while they pause and resume saving, and to set or
+// reconnect serverRcon. The user check skips a tick rather than wait.
+var rconInUse = make(chan struct{}, 1)
+
+// rconInUse for SnapshotOptions.RconLock.
+type rconLock struct{}
+
+func (rconLock) Lock() { rconInUse <- struct{}{} }
+func (rconLock) Unlock() { <-rconInUse }
+
+// time.Tick never goes off for a duration <= 0.
+func checkContinuousDelays() (error) {
+  if backupDelayArg <= 0 { return fmt.Errorf("--backup-delay must be more than 0, not %s", backupDelayArg) }
+  if userCheckDelayArg <= 0 { return fmt.Errorf("--user-check-delay must be more than 0, not %s", userCheckDelayArg) }
+  return nil
+}
+
+// Snapshots are taken when users come or go, and then either every backupDelayArg
+// or, if there are any, on the cron schedules. When the last user leaves we take
+// one more and then idle until someone joins. Nothing but that last one is taken
+// during quiet hours.
+// SIGUSR1 asks for a World and Server snapshot regardless.
+// The snapshots themselves are run, one at a time, by a SnapshotCoordinator.
+// Runs until SIGTERM or SIGINT, then takes final snapshots and returns the exit status.
+func continuousArchiveAndPublish(s *mclib.Server) (int) {
 
   f := s.LogFields()
-  f["serverBackupTick"] = backupDelay.String()
-  f["userCheckTick"] = userCheckDelay.String()
+  f["userCheckTick"] = userCheckDelayArg.String()
+  f["snapshotTimeout"] = snapshotTimeoutArg.String()
+  f["heartbeat"] = heartbeatArg.String()
   f["controllerVersion"] = version.Version.String()
   f["operation"] = "SnapshotCheck"
+  if len(snapshotSchedules) == 0 {
+    f["serverBackupTick"] = backupDelayArg.String()
+  }
+  for _, ss := range snapshotSchedules {
+    f[ss.Type.String() + "Schedule"] = ss.Cron.String()
+  }
+  if len(quietHours) > 0 {
+    windows := make([]string, len(quietHours))
+    for i, q := range quietHours { windows[i] = q.String() }
+    f["quietHours"] = strings.Join(windows, ",")
+  }
+  log.Info(f, "Starting continuous snapshots.")
 
+  coordinator := lib.NewSnapshotCoordinator(s, func(ctx context.Context, aType mclib.ArchiveType) (error) {
+    return archiveAndPublish(ctx, s, aType)
+  }, snapshotTimeoutArg)
+  if pruneArg {
+    coordinator.AfterBatch = func() { pruneArchives(s) }
+  }
+  coordinator.Start()
 
-  backupTimeoutCheck := time.Tick(backupDelay)
-  newUserCheck := time.Tick(userCheckDelay)
+  if archiveSpool != nil {
+    if store, err := lib.NewArchiveStore(s.ArchiveBucket, s.AWSSession); err == nil {
+      ctx, cancel := context.WithCancel(context.Background())
+      defer cancel()
+      go lib.NewSpoolStore(store, archiveSpool).Retry(ctx, spoolRetryDelay, spoolMaxRetryDelay)
+    } else {
+      log.Error(f, "Can't retry spooled uploads.", err)
+    }
+  }
+
+  if httpAddrArg != "" {
+    metrics = newControllerMetrics(s)
+    srv := startStatusServer(s)
+    defer srv.Close()
+  }
+
+  var backupTimeoutCheck <-chan time.Time
+  if len(snapshotSchedules) == 0 {
+    backupTimeoutCheck = time.Tick(backupDelayArg)
+  }
+  newUserCheck := time.Tick(userCheckDelayArg)
+  var heartbeat <-chan time.Time
+  if heartbeatArg > 0 {
+    heartbeat = time.Tick(heartbeatArg)
+    logStatus(s)
+  }
+  stop := make(chan os.Signal, 1)
+  signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+  snapshotNow := make(chan os.Signal, 1)
+  signal.Notify(snapshotNow, syscall.SIGUSR1)
+
+  // When each schedule next goes off.
+  nextRun := make([]time.Time, len(snapshotSchedules))
+  for i, ss := range snapshotSchedules {
+    nextRun[i] = ss.Cron.Next(time.Now())
+  }
 
   var err error
   lastUsers := 0
   currentUsers := 0
+  rconLost := false
   wakeUpReason := newUser
   for {
+    var scheduleCheck <-chan time.Time
+    if len(nextRun) > 0 {
+      scheduleCheck = time.After(time.Until(earliest(nextRun)))
+    }
+    var due []mclib.ArchiveType
     select {
+    case sig := <- stop:
+      return stopContinuous(s, sig, stop, coordinator)
+    case <- heartbeat:
+      logStatus(s)
+      continue
+    case <- snapshotNow:
+      wakeUpReason = manual
     case <- newUserCheck:           
       wakeUpReason = newUser
     case <- backupTimeoutCheck:
       wakeUpReason = backupTimeout
+    case now := <- scheduleCheck:
+      wakeUpReason = scheduled
+      for i, ss := range snapshotSchedules {
+        if !nextRun[i].After(now) {
+          due = append(due, ss.Type)
+          nextRun[i] = ss.Cron.Next(now)
+        }
+      }
     }
 
-    // TODO: We may do a backup down here, synchronously.
-    // This may cause the events above to backup (so far that's unlikely
-    // as backups take about 1 sec. Don't know what,if anythnig happens if that
-    // happens. But it's likely better than getting the asynchronous part wrong. 
-    // We could don't wnat world saves (via the telling the server to say through rcon) 
-    // to go off during another backup.
+    if wakeUpReason == manual {
+      f["snapshotType"] = "<all>"
+      log.Info(f, "Snapshot requested.")
+      coordinator.Request(lib.ReasonManual, mclib.WorldSnapshot, mclib.ServerSnapshot)
+      continue
+    }
 
-    // Don't do backups if there are no users.
-    currentUsers, err = s.Rcon.NumberOfUsers();
-    change := currentUsers != lastUsers
+    // Don't do backups if there are no users, except to catch the
+    // end of a session when the last one leaves.
+    var busy bool
+    if currentUsers, busy, err = checkUsers(s); busy {
+      if wakeUpReason == newUser {
+        log.Debug(f, "Snapshot pausing or resuming saving, skipping user check.")
+        continue
+      }
+      // Timers and schedules still go off, with the last count.
+      currentUsers = lastUsers
+    }
     if err != nil {
       f["users"] = "<unknown>"
       log.Error(f, "Can't get the number of users from the server. Will wait.", err)
-      s.NewRcon() // TODO: Consider doing this with a retry.
-    } else {
-      f["users"] = currentUsers
-      // If there are users, backup worlds and server every backuptimeout.
-      // If we add or remove a user then catch that in a world backup.
-      // TODO: FOR THE MOMENT IT SEEMS CLEAR THAT WE SHOULD RESTART FROM
-      // SERVER BACKUPs. Which means I need them to happen more often.
-      if currentUsers > 0 {
-        f["operation"] = "SnapshotCheck"
-
-        if change && wakeUpReason == newUser {
-          f["operation"] = "Snapshot"
-
-          f["snapshotType"] = mclib.WorldSnapshot.String()
-          log.Info(f, "Taking snapshot.")
-          archiveAndPublish(s, mclib.WorldSnapshot)
-
-          f["snapshotType"] = mclib.ServerSnapshot.String()
-          log.Info(f, "Taking snapshot.")
-          archiveAndPublish(s, mclib.ServerSnapshot)
-        } else if wakeUpReason == backupTimeout {
-          f["operation"] = "Snapshot"
-
-          f["snapshotType"] = mclib.WorldSnapshot.String()
-          log.Info(f, "Taking snapshot.")
-          archiveAndPublish(s, mclib.WorldSnapshot)
-
-          f["snapshotType"] = mclib.ServerSnapshot.String()
-          log.Info(f, "Taking snapshot.")
-          archiveAndPublish(s, mclib.ServerSnapshot)
-        } else {
-          f["snapshotType"] = "<none>"
-          log.Info(f, "No change in number of users. Not archiving")
-        }
-      } else {
-        f["snapshotType"] = "<none>"
-        log.Info(f, "No users on server. Not archiving")
+      if !rconLost {
+        rconLost = true
+        notifier.Notify(lib.EventRconLost, map[string]interface{}{"error": err.Error()})
       }
+      continue
+    }
+    if rconLost {
+      rconLost = false
+      notifier.Notify(lib.EventRconRecovered, nil)
+    }
+    change := currentUsers != lastUsers
+    if change {
+      notifier.Notify(lib.EventUsersChanged, map[string]interface{}{"users": currentUsers, "previousUsers": lastUsers})
     }
     lastUsers = currentUsers
+    f["users"] = currentUsers
+    f["operation"] = "SnapshotCheck"
+
+    // If there are users, backup worlds and server every backuptimeout.
+    // If we add or remove a user then catch that in a world backup.
+    // TODO: FOR THE MOMENT IT SEEMS CLEAR THAT WE SHOULD RESTART FROM
+    // SERVER BACKUPs. Which means I need them to happen more often.
+    var types []mclib.ArchiveType
+    reason := ""
+    switch {
+    case currentUsers == 0 && change:
+      log.Info(f, "Last user left, taking end of session snapshots.")
+      types = []mclib.ArchiveType{mclib.WorldSnapshot, mclib.ServerSnapshot}
+      reason = lib.ReasonSessionEnd
+    case currentUsers == 0:
+      f["snapshotType"] = "<none>"
+      log.Debug(f, "No users on server, idle. Not archiving")
+      continue
+    case change && wakeUpReason == newUser:
+      types = []mclib.ArchiveType{mclib.WorldSnapshot, mclib.ServerSnapshot}
+      reason = lib.ReasonUserChange
+    case wakeUpReason == backupTimeout:
+      types = []mclib.ArchiveType{mclib.WorldSnapshot, mclib.ServerSnapshot}
+      reason = lib.ReasonTimer
+    case wakeUpReason == scheduled:
+      types = due
+      reason = lib.ReasonSchedule
+    }
+
+    if len(types) == 0 {
+      f["snapshotType"] = "<none>"
+      log.Info(f, "No change in number of users. Not archiving")
+    } else if quiet(reason, time.Now()) {
+      f["snapshotType"] = "<none>"
+      log.Info(f, "Quiet hours. Not archiving")
+    } else {
+      names := make([]string, len(types))
+      for i, t := range types { names[i] = t.String() }
+      f["snapshotType"] = strings.Join(names, ",")
+      f["reason"] = reason
+      log.Info(f, "Requesting snapshot.")
+      coordinator.Request(reason, types...)
+      delete(f, "reason")
+    }
+  }
+}
+
+// Quiet hours keep snapshots off the server. The one when the last user leaves
+// is the only record of the end of their session, and there's no one on to slow down.
+func quiet(reason string, t time.Time) (bool) {
+  return reason != lib.ReasonSessionEnd && lib.InQuietHours(quietHours, t)
+}
+
+// Take a last World and Server snapshot, giving up after stopTimeoutArg
+// or another signal, and make sure the server is left saving.
+func stopContinuous(s *mclib.Server, sig os.Signal, stop <-chan os.Signal, coordinator *lib.SnapshotCoordinator) (status int) {
+  f := s.LogFields()
+  f["operation"] = "Stop"
+  f["signal"] = sig.String()
+  f["stopTimeout"] = stopTimeoutArg.String()
+  log.Info(f, "Stopping, taking final snapshots.")
+
+  coordinator.Request(lib.ReasonStop, mclib.WorldSnapshot, mclib.ServerSnapshot)
+  done := coordinator.Close()
+
+  status = exitOK
+  select {
+  case err := <- done:
+    if err != nil {
+      status = exitSnapshotFailed
+      log.Error(f, "Final snapshots failed.", err)
+    }
+  case <- time.After(stopTimeoutArg):
+    status = exitStopTimeout
+    log.Error(f, "Final snapshots didn't finish in time.", fmt.Errorf("Timed out after %s", stopTimeoutArg))
+  case sig = <- stop:
+    status = exitStopTimeout
+    log.Error(f, "Signalled again, not waiting for the final snapshots.", fmt.Errorf("Received %s", sig))
+  }
+
+  if status == exitStopTimeout {
+    // Give a cancelled snapshot a moment to turn saving back on itself.
+    coordinator.Abort()
+    select {
+    case <- done:
+    case <- time.After(5 * time.Second):
+    }
+  }
+  ensureSavingOn(s)
+  f["exitStatus"] = status
+  log.Info(f, "Stopped.")
+  return status
+}
+
+// A snapshot may still be using the server's connection, so use a new one.
+func ensureSavingOn(s *mclib.Server) {
+  f := s.LogFields()
+  f["operation"] = "Stop"
+  rcon, err := lib.NewRconClient(s.PublicServerIp, s.RconPort, s.RconPassword)
+  if err == nil {
+    _, err = rcon.Send("save-on")
+    rcon.Close()
+  }
+  if err != nil {
+    log.Error(f, "Couldn't make sure saving is on.", err)
+    return
   }
+  log.Debug(f, "Saving is on.")
+}
+
+func earliest(times []time.Time) (t time.Time) {
+  for i, tt := range times {
+    if i == 0 || tt.Before(t) { t = tt }
+  }
+  return t
 }
 
 // Check for users, do the backup and report out.
-func archiveAndPublish(s *mclib.Server, aType mclib.ArchiveType) {
+func archiveAndPublish(ctx context.Context, s *mclib.Server, aType mclib.ArchiveType) (error) {
   f := s.LogFields()
   f["serverDir"] = s.ServerDirectory
   f["bucket"] = s.ArchiveBucket
   f["snapshotType"] = aType.String()
   f["operation"] = "Snapshot"
 
-  var resp *mclib.PublishedArchiveResponse
-  var err error
-  switch aType {
-  case mclib.ServerSnapshot:
-    resp, err = s.TakeServerSnapshot()
-  case mclib.WorldSnapshot: 
-    resp, err = s.TakeWorldSnapshot()
-  default:
-    resp = nil
-    err = fmt.Errorf("Error archiving: Bad ArchiveType: %s", aType.String())
+  start := time.Now()
+  var result *lib.SnapshotResult
+  store, err := archiveStore(s)
+  if err == nil {
+    switch aType {
+    case mclib.ServerSnapshot, mclib.WorldSnapshot:
+      result, err = lib.TakeSnapshotContext(ctx, s, aType, store, snapshotOptions())
+    default:
+      err = fmt.Errorf("Error archiving: Bad ArchiveType: %s", aType.String())
+    }
   }
 
   if err != nil {
     f["result"] = "Failure"
-    log.Error(f, "Error creating and publishing an archive to S3.", err)
+    log.Error(f, "Error creating and publishing an archive.", err)
+    controller.snapshotDone(aType, "Failure", "", err)
+    notifier.Notify(lib.EventSnapshotFailure, map[string]interface{}{"snapshotType": aType.String(), "error": err.Error()})
+    metrics.snapshotDone(aType, "Failure", 0, time.Since(start))
+  } else {
+    f["uri"] = result.URI
+    f["archive"] = result.Object.Key
+    f["eTag"] =  result.Object.ETag
+    f["files"] = result.Files
+    event := lib.EventSnapshotSuccess
+    if result.Object.Spooled {
+      f["result"] = "Spooled"
+      event = lib.EventSnapshotSpooled
+      log.Info(f, "Snapshot taken, upload spooled for retry.")
+    } else {
+      f["result"] = "Success"
+      log.Info(f, "Snapshot successful.")
+    }
+    controller.snapshotDone(aType, f["result"].(string), result.URI, nil)
+    notifier.Notify(event, map[string]interface{}{
+      "snapshotType": aType.String(),
+      "result": f["result"],
+      "uri": result.URI,
+      "size": result.Object.Size,
+    })
+    metrics.snapshotDone(aType, f["result"].(string), result.Uploaded, time.Since(start))
+  }
+  return err
+}
+
+// Uploads go through the spool when there is one.
+func archiveStore(s *mclib.Server) (lib.ArchiveStore, error) {
+  store, err := lib.NewArchiveStore(s.ArchiveBucket, s.AWSSession)
+  if err != nil || archiveSpool == nil { return store, err }
+  return lib.NewSpoolStore(store, archiveSpool), nil
+}
+
+func snapshotOptions() (lib.SnapshotOptions) {
+  opts := lib.SnapshotOptions{
+    Incremental: incrementalArg,
+    Exclude: excludeArg,
+    Format: lib.ArchiveFormat(formatArg),
+    Encryption: archiveEncryption,
+    SaveTimeout: saveTimeoutArg,
+    RconLock: rconLock{},
+  }
+  rconInUse <- struct{}{}
+  if serverRcon != nil { opts.Rcon = serverRcon }
+  <-rconInUse
+  return opts
+}
+
+// Apply the retention policy to this server's archives.
+func pruneArchives(s *mclib.Server) {
+  policy := lib.RetentionPolicy{
+    KeepLast: keepLastArg,
+    Daily: keepDailyArg,
+    Weekly: keepWeeklyArg,
+    Monthly: keepMonthlyArg,
+  }
+  f := s.LogFields()
+  f["bucket"] = s.ArchiveBucket
+  f["policy"] = policy.String()
+  f["operation"] = "Prune"
+
+  store, err := lib.NewArchiveStore(s.ArchiveBucket, s.AWSSession)
+  var al []lib.ArchiveEntry
+  if err == nil { al, err = lib.ListArchives(store, s.User) }
+  if err != nil {
+    f["result"] = "Failure"
+    log.Error(f, "Couldn't get the list of archives to prune.", err)
+    return
+  }
+
+  decisions, err := lib.PruneArchives(store, lib.ServerArchives(al, s.Name), policy, false)
+  pruned := 0
+  for _, d := range decisions {
+    if !d.Keep { pruned++ }
+  }
+  f["archives"] = len(decisions)
+  f["pruned"] = pruned
+  if err != nil {
+    f["result"] = "Failure"
+    log.Error(f, "Error pruning archives.", err)
   } else {
-    f["uri"] = resp.URI()
-    f["archive"] = resp.Key
-    f["eTag"] =  *resp.PutObjectOutput.ETag
     f["result"] = "Success"
-    log.Info(f, "Snapshot successful.")
+    log.Info(f, "Pruned archives.")
   }
 }
 
+// The heartbeat, logged every heartbeatArg in continuous mode. Its operation
+// never changes so a missing heartbeat can be alerted on.
+const heartbeatOperation = "StatusHeartbeat"
+
 func logStatus(s *mclib.Server) {
   f := s.LogFields()
+  f["operation"] = heartbeatOperation
   f["controllerVersion"] = version.Version.String()
-  if users, err := s.Rcon.NumberOfUsers(); err == nil {
-    f["users"] = users
-  } else {
-    log.Error(f,"Failed to get number of users from server.", err)
+  select {
+  case rconInUse <- struct{}{}:
+    start := time.Now()
+    users, err := numberOfUsers()
+    latency := time.Since(start)
+    <-rconInUse
+    if err == nil {
+      f["users"] = users
+      f["rconLatency"] = latency.String()
+    } else {
+      f["users"] = "<unknown>"
+      f["rconLatency"] = "<unreachable>"
+      f["rconError"] = err.Error()
+    }
+  default:
+    f["users"] = "<busy>"
+    f["rconLatency"] = "<busy>"
   }
   f["serverUptime"] = s.UptimeString()
   f["craftType"] = s.CraftType()
+  for t, since := range controller.sinceSuccess(time.Now()) {
+    f[t + "SinceSuccess"] = since
+  }
+  log.Info(f, "Status.")
 }
 
@@ -0,0 +1,160 @@
+package main
+
+import (
+  "context"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "sync"
+  "testing"
+  "time"
+  "craft-config/lib"
+  "craft-config/lib/rcontest"
+  "github.com/stretchr/testify/assert"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+func testServer(t *testing.T, srv *rcontest.Server) (s *mclib.Server, cleanup func()) {
+  serverDir, err := ioutil.TempDir("", "archive-server")
+  assert.NoError(t, err)
+  storeDir, err := ioutil.TempDir("", "archive-store")
+  assert.NoError(t, err)
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "server.properties"), []byte("level-name=world\n"), 0644))
+  assert.NoError(t, os.MkdirAll(filepath.Join(serverDir, "world"), 0755))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "world", "level.dat"), []byte("level"), 0644))
+  s = &mclib.Server{
+    User: "testuser",
+    Name: "testserver",
+    PublicServerIp: srv.Host(),
+    RconPort: mclib.Port(srv.Port()),
+    RconPassword: srv.Password,
+    ServerDirectory: serverDir,
+    ArchiveBucket: "file://" + storeDir,
+  }
+  return s, func() {
+    os.RemoveAll(serverDir)
+    os.RemoveAll(storeDir)
+    serverRcon = nil
+  }
+}
+
+func TestArchiveAndPublish(t *testing.T) {
+  srv, err := rcontest.NewServer("secret")
+  if !assert.NoError(t, err) { return }
+  defer srv.Close()
+  s, cleanup := testServer(t, srv)
+  defer cleanup()
+  rconRetriesArg = 0
+  continuousArchiveArg = false
+
+  assert.Equal(t, exitOK, runArchiveAndPublish(s))
+  assert.Equal(t, []string{"save-off", "save-all flush", "save-on"}, srv.Commands())
+  store, err := lib.NewArchiveStore(s.ArchiveBucket, nil)
+  assert.NoError(t, err)
+  objs, err := store.List("testuser/testserver/" + mclib.ServerSnapshot.String())
+  assert.NoError(t, err)
+  assert.Len(t, objs, 2) // The archive and its manifest.
+
+  // Saving still goes back on when the snapshot fails.
+  os.RemoveAll(s.ServerDirectory)
+  assert.Equal(t, exitSnapshotFailed, runArchiveAndPublish(s))
+  assert.Equal(t, "save-on", srv.Commands()[len(srv.Commands()) - 1])
+}
+
+func TestCheckUsers(t *testing.T) {
+  srv, err := rcontest.NewServer("secret")
+  if !assert.NoError(t, err) { return }
+  defer srv.Close()
+  s, cleanup := testServer(t, srv)
+  defer cleanup()
+  serverRcon, err = connectRcon(s, 0, 0)
+  if !assert.NoError(t, err) { return }
+
+  srv.SetUsers("alice", "bob")
+  users, busy, err := checkUsers(s)
+  assert.NoError(t, err)
+  assert.False(t, busy)
+  assert.Equal(t, 2, users)
+
+  // A snapshot has the connection.
+  rconInUse <- struct{}{}
+  _, busy, _ = checkUsers(s)
+  <-rconInUse
+  assert.True(t, busy)
+
+  // Losing the connection fails one check, the next one reconnects.
+  srv.Disconnect()
+  _, _, err = checkUsers(s)
+  assert.Error(t, err)
+  srv.SetUsers()
+  users, _, err = checkUsers(s)
+  assert.NoError(t, err)
+  assert.Equal(t, 0, users)
+  assert.Equal(t, 2, srv.Logins())
+}
+
+func TestSpooledSnapshot(t *testing.T) {
+  srv, err := rcontest.NewServer("secret")
+  if !assert.NoError(t, err) { return }
+  defer srv.Close()
+  s, cleanup := testServer(t, srv)
+  defer cleanup()
+  spoolDir, err := ioutil.TempDir("", "archive-spool")
+  assert.NoError(t, err)
+  defer os.RemoveAll(spoolDir)
+
+  var mu sync.Mutex
+  var events []string
+  hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+    mu.Lock()
+    events = append(events, req.Header.Get(lib.WebhookEventHeader))
+    mu.Unlock()
+  }))
+  defer hook.Close()
+  savedNotifier, savedSpool, savedController := notifier, archiveSpool, controller
+  defer func() { notifier, archiveSpool, controller = savedNotifier, savedSpool, savedController }()
+  notifier, err = lib.NewNotifier(s, []string{hook.URL}, "", nil)
+  assert.NoError(t, err)
+  archiveSpool, err = lib.OpenSpool(spoolDir, 1 << 20)
+  assert.NoError(t, err)
+  controller = newControllerState()
+
+  // The store can't be written to.
+  s.ArchiveBucket = "file:///dev/null/archives"
+  assert.NoError(t, archiveAndPublish(context.Background(), s, mclib.WorldSnapshot))
+  assert.True(t, notifier.Wait(time.Second))
+  assert.Equal(t, []string{lib.EventSnapshotSpooled}, events)
+  assert.True(t, controller.report(0, time.Now()).LastSuccess.IsZero())
+}
+
+func TestQuietHours(t *testing.T) {
+  q, err := lib.ParseQuietHours("02:00-06:00")
+  if !assert.NoError(t, err) { return }
+  saved := quietHours
+  quietHours = []lib.QuietHours{q}
+  defer func() { quietHours = saved }()
+
+  night := time.Date(2017, 1, 1, 3, 0, 0, 0, time.Local)
+  day := time.Date(2017, 1, 1, 12, 0, 0, 0, time.Local)
+  assert.True(t, quiet(lib.ReasonTimer, night))
+  assert.True(t, quiet(lib.ReasonUserChange, night))
+  assert.False(t, quiet(lib.ReasonTimer, day))
+  // The last one of a session is always taken.
+  assert.False(t, quiet(lib.ReasonSessionEnd, night))
+}
+
+func TestCheckContinuousDelays(t *testing.T) {
+  savedBackup, savedUserCheck := backupDelayArg, userCheckDelayArg
+  defer func() { backupDelayArg, userCheckDelayArg = savedBackup, savedUserCheck }()
+
+  backupDelayArg, userCheckDelayArg = 5 * time.Minute, 30 * time.Second
+  assert.NoError(t, checkContinuousDelays())
+  backupDelayArg = 0
+  assert.Error(t, checkContinuousDelays())
+  backupDelayArg, userCheckDelayArg = 5 * time.Minute, -time.Second
+  assert.Error(t, checkContinuousDelays())
+}
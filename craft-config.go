@@ -3,7 +3,12 @@ package main
 import (
   "fmt"
   "github.com/alecthomas/kingpin"
+  "github.com/alecthomas/units"
   "os"
+  "path/filepath"
+  "strings"
+  "text/tabwriter"
+  "time"
   "craft-config/interactive"
   "craft-config/lib"
   "craft-config/version"
@@ -23,6 +28,7 @@ import (
 var (
   DEFAULT_REGION = "us-west-1"
   DefaultBucket = "momentlabs-test"
+  defaultSpoolDir = filepath.Join(os.TempDir(), "craft-config-spool")
 )
 
 var (
@@ -31,6 +37,9 @@ var (
   debug                             bool
   awsRegionArg                      string
   awsProfileArg                     string
+  s3EndpointArg                     string
+  s3PathStyleArg                    bool
+  s3DisableSSLArg                   bool
   // awsConfigFileArg                  string
   logsFormatArg                     string
 
@@ -40,6 +49,9 @@ var (
   versionCmd                        *kingpin.CmdClause
   queryCmd                          *kingpin.CmdClause
   queryArg                          []string
+  queryStdinArg                     bool
+  queryFileArg                      string
+  queryOutputArg                    string
   serverConfig                      *kingpin.CmdClause
   listServerConfig                  *kingpin.CmdClause
   serverConfigFileName              string
@@ -60,6 +72,44 @@ var (
   serverIpArg                       string
   rconPortArg                       int64
   rconPasswordArg                   string
+  incrementalArg                    bool
+  excludeArg                        []string
+  formatArg                         string
+  encryptToArg                      []string
+  keyFileArg                        string
+  passphraseFileArg                 string
+  archiveEncryption                 *lib.Encryption
+  backupDelayArg                    time.Duration
+  userCheckDelayArg                 time.Duration
+  scheduleArg                       map[string]string
+  quietHoursArg                     []string
+  stopTimeoutArg                    time.Duration
+  snapshotTimeoutArg                time.Duration
+  saveTimeoutArg                    time.Duration
+  heartbeatArg                      time.Duration
+  snapshotSchedules                 []lib.SnapshotSchedule
+  quietHours                        []lib.QuietHours
+  pruneArg                          bool
+  keepLastArg                       int
+  keepDailyArg                      int
+  keepWeeklyArg                     int
+  keepMonthlyArg                    int
+
+  spoolDirArg                       string
+  spoolLimitArg                     units.Base2Bytes
+  archiveSpool                      *lib.Spool
+  httpAddrArg                       string
+  healthSLOArg                      time.Duration
+  webhookArg                        []string
+  webhookSecretArg                  string
+  webhookEventsArg                  []string
+  webhookRetriesArg                 int
+  webhookRetryDelayArg              time.Duration
+
+  statusCmd                         *kingpin.CmdClause
+
+  restoreCmd                        *kingpin.CmdClause
+  restoreURIArg                     string
 
   log = sl.New()
   sess *session.Session
@@ -80,12 +130,19 @@ func init() {
   // app.Flag("aws-config", "Configuration file location.").StringVar(&awsConfigFileArg)
   app.Flag("region", "Aws region to use as a default (publishing archives.)").StringVar(&awsRegionArg)
   app.Flag("profile", "AWS profile for configuration.").StringVar(&awsProfileArg)
+  app.Flag("s3-endpoint", "Endpoint for an S3-compatible archive store (MinIO, Ceph etc.): http://minio:9000").StringVar(&s3EndpointArg)
+  app.Flag("s3-path-style", "Use path-style addressing (endpoint/bucket/key) for the archive store.").BoolVar(&s3PathStyleArg)
+  app.Flag("s3-disable-ssl", "Don't use SSL to talk to the archive store.").BoolVar(&s3DisableSSLArg)
 
   interactiveCmd = app.Command("interactive", "Prompt for commands.")
 
   versionCmd = app.Command("version", "Print the version and exit.")
-  queryCmd = app.Command("query", "Issues a command to the RCON port of a server.")
-  queryCmd.Arg("query-command", "command string to the server.").Required().StringsVar(&queryArg)
+  queryCmd = app.Command("query", "Issues a command to the RCON port of a server, or starts a prompt without one.")
+  queryCmd.Arg("query-command", "command string to the server.").StringsVar(&queryArg)
+  queryCmd.Flag("stdin", "Send each line of stdin as a command.").BoolVar(&queryStdinArg)
+  queryCmd.Flag("file", "Send each line of this file as a command.").StringVar(&queryFileArg)
+  queryCmd.Flag("output", "Print responses as text, or JSON with list, whitelist list, banlist, seed, time query and tps parsed.").
+    Default(lib.OutputText).EnumVar(&queryOutputArg, lib.OutputFormats...)
   queryCmd.Flag("server-ip", "IP address of server to connect with.").Default("127.0.0.1").StringVar(&serverIpArg)
   queryCmd.Flag("rcon-pw", "Password for rcon").Default("testing").StringVar(&rconPasswordArg)
 
@@ -99,7 +156,7 @@ func init() {
   modifyServerConfig.Flag("source-file", "Source configuration to read.").Default("server.cfg").Short('s').StringVar(&serverConfigFileName)
   modifyServerConfig.Flag("dest-file", "Modified file to write. If not then new config goes to stdout.").Required().Short('d').StringVar(&newServerConfigFileName)
 
-  archiveAndPublishCmd = app.Command("archive", "Archive a server and Publish archive to S3.")  
+  archiveAndPublishCmd = app.Command("archive", "Archive a server and Publish archive to S3 or a local directory.")  
   archiveAndPublishCmd.Flag("continuous", "Continously archive and publish, when users are logged into the server.").BoolVar(&continuousArchiveArg)
   archiveAndPublishCmd.Flag("server-ip", "IP address for the rcon server connection.").Default("127.0.0.1").StringVar(&serverIpArg)
   archiveAndPublishCmd.Flag("noPublish", "Don't publish the archive to S3, just create it.").Default("true").BoolVar(&publishArchiveArg)
@@ -109,10 +166,66 @@ func init() {
   archiveAndPublishCmd.Flag("rcon-retries", "Number of times to retry the connection before failure..").Default("-1").IntVar(&rconRetriesArg)
   archiveAndPublishCmd.Flag("rcon-delay", "Number of seconds to wait between retries.").Default("5").IntVar(&rconDelayArg)
   archiveAndPublishCmd.Flag("archive-directory","Where the server data is located.").Default(".").StringVar(&archiveDirectoryArg)
-  archiveAndPublishCmd.Flag("bucket-name","S3 bucket for archive storage.").Default(DefaultBucket).StringVar(&bucketNameArg)
+  archiveAndPublishCmd.Flag("bucket-name","Archive storage: an S3 bucket name, s3://bucket[/prefix] or file:///directory.").Default(DefaultBucket).StringVar(&bucketNameArg)
+  archiveAndPublishCmd.Flag("incremental", "Store snapshots as content-addressed files and a manifest, only uploading what changed.").BoolVar(&incrementalArg)
+  archiveAndPublishCmd.Flag("exclude", "Leave files matching this .gitignore style pattern out of snapshots, in addition to .craftignore. Repeatable.").StringsVar(&excludeArg)
+  archiveAndPublishCmd.Flag("format", "Archive format: " + strings.Join(lib.ArchiveFormats, ", ") + ". Ignored for incremental snapshots.").Default(string(lib.DefaultFormat)).EnumVar(&formatArg, lib.ArchiveFormats...)
+  archiveAndPublishCmd.Flag("encrypt-to", "Encrypt archives to this age public key (age1...). Repeatable.").StringsVar(&encryptToArg)
+  archiveAndPublishCmd.Flag("key-file", "Encrypt archives to the keys in this age identity file.").StringVar(&keyFileArg)
+  archiveAndPublishCmd.Flag("passphrase-file", "Encrypt archives with the passphrase in this file.").StringVar(&passphraseFileArg)
+  archiveAndPublishCmd.Flag("save-timeout", "How long to wait for the server to confirm it saved the world before a snapshot.").
+    Default("30s").Envar("CRAFT_SAVE_TIMEOUT").DurationVar(&saveTimeoutArg)
+  archiveAndPublishCmd.Flag("backup-delay", "Continuous: how often to snapshot while there are users, when there's no --schedule.").
+    Default("5m").Envar("CRAFT_BACKUP_DELAY").DurationVar(&backupDelayArg)
+  archiveAndPublishCmd.Flag("user-check-delay", "Continuous: how often to check for users coming and going.").
+    Default("30s").Envar("CRAFT_USER_CHECK_DELAY").DurationVar(&userCheckDelayArg)
+  archiveAndPublishCmd.Flag("schedule", "Continuous: cron schedule for a snapshot type, e.g. WorldSnapshot='*/5 * * * *'. Repeatable, replaces --backup-delay.").
+    Envar("CRAFT_SNAPSHOT_SCHEDULE").StringMapVar(&scheduleArg)
+  archiveAndPublishCmd.Flag("quiet-hours", "Continuous: don't snapshot between these local times, e.g. 02:00-06:00, except when the last user leaves. Repeatable.").
+    Envar("CRAFT_QUIET_HOURS").StringsVar(&quietHoursArg)
+  archiveAndPublishCmd.Flag("heartbeat", "Continuous: log a " + heartbeatOperation + " this often, 0 for never.").
+    Default("5m").Envar("CRAFT_HEARTBEAT").DurationVar(&heartbeatArg)
+  archiveAndPublishCmd.Flag("snapshot-timeout", "Continuous: cancel a snapshot that takes longer than this.").
+    Default("10m").Envar("CRAFT_SNAPSHOT_TIMEOUT").DurationVar(&snapshotTimeoutArg)
+  archiveAndPublishCmd.Flag("stop-timeout", "Continuous: how long the final snapshots can take when stopped with SIGTERM or SIGINT.").
+    Default("25s").Envar("CRAFT_STOP_TIMEOUT").DurationVar(&stopTimeoutArg)
+  archiveAndPublishCmd.Flag("spool-dir", "Continuous: keep failed uploads here and retry them in the background.").
+    Default(defaultSpoolDir).Envar("CRAFT_SPOOL_DIR").StringVar(&spoolDirArg)
+  archiveAndPublishCmd.Flag("spool-limit", "Continuous: most the spool will hold, 0 to turn spooling off.").
+    Default("2GB").Envar("CRAFT_SPOOL_LIMIT").BytesVar(&spoolLimitArg)
+  archiveAndPublishCmd.Flag("http-addr", "Continuous: serve /healthz, /status and /metrics on this address, e.g. :8080.").
+    Envar("CRAFT_HTTP_ADDR").StringVar(&httpAddrArg)
+  archiveAndPublishCmd.Flag("health-slo", "Continuous: unhealthy if there are users and no successful snapshot for this long.").
+    Default("30m").Envar("CRAFT_HEALTH_SLO").DurationVar(&healthSLOArg)
+  archiveAndPublishCmd.Flag("webhook", "POST snapshot and server events as JSON to this URL. Repeatable.").
+    Envar("CRAFT_WEBHOOK").StringsVar(&webhookArg)
+  archiveAndPublishCmd.Flag("webhook-secret", "Sign webhook bodies with HMAC-SHA256 using this secret, in the " + lib.WebhookSignatureHeader + " header.").
+    Envar("CRAFT_WEBHOOK_SECRET").StringVar(&webhookSecretArg)
+  archiveAndPublishCmd.Flag("webhook-event", "Only send this event: " + strings.Join(lib.WebhookEvents, ", ") + ". Repeatable, all of them by default.").
+    Envar("CRAFT_WEBHOOK_EVENTS").StringsVar(&webhookEventsArg)
+  archiveAndPublishCmd.Flag("webhook-retries", "Times to retry a webhook that fails.").
+    Default("3").Envar("CRAFT_WEBHOOK_RETRIES").IntVar(&webhookRetriesArg)
+  archiveAndPublishCmd.Flag("webhook-retry-delay", "Wait before the first webhook retry, doubling for each one after.").
+    Default("5s").Envar("CRAFT_WEBHOOK_RETRY_DELAY").DurationVar(&webhookRetryDelayArg)
+  archiveAndPublishCmd.Flag("prune", "Prune archives with the retention policy after each continuous snapshot.").BoolVar(&pruneArg)
+  archiveAndPublishCmd.Flag("keep-last", "Retention: keep this many of the most recent archives.").Default("12").IntVar(&keepLastArg)
+  archiveAndPublishCmd.Flag("keep-daily", "Retention: keep the newest archive for this many days.").Default("7").IntVar(&keepDailyArg)
+  archiveAndPublishCmd.Flag("keep-weekly", "Retention: keep the newest archive for this many weeks.").Default("4").IntVar(&keepWeeklyArg)
+  archiveAndPublishCmd.Flag("keep-monthly", "Retention: keep the newest archive for this many months.").Default("6").IntVar(&keepMonthlyArg)
   archiveAndPublishCmd.Arg("user", "Name of user of the server were achiving.").StringVar(&userArg)
   archiveAndPublishCmd.Arg("server-name", "Name of the server were archiving.").StringVar(&serverNameArg)
 
+  statusCmd = app.Command("status", "Show uploads waiting in the spool.")
+  statusCmd.Flag("spool-dir", "Spool directory.").Default(defaultSpoolDir).Envar("CRAFT_SPOOL_DIR").StringVar(&spoolDirArg)
+
+  restoreCmd = app.Command("restore", "Download an archive and restore it into the server directory. The server must be stopped.")
+  restoreCmd.Arg("uri", "Fully qualified URI for the archive: s3://bucket/key or file:///path").Required().StringVar(&restoreURIArg)
+  restoreCmd.Flag("archive-directory", "Server directory to restore into.").Default(".").StringVar(&archiveDirectoryArg)
+  restoreCmd.Flag("server-ip", "IP address for the rcon server connection, used to check the server is stopped.").Default("127.0.0.1").StringVar(&serverIpArg)
+  restoreCmd.Flag("rcon-port", "Port of server for rcon connection.").Default("25575").Int64Var(&rconPortArg)
+  restoreCmd.Flag("key-file", "age identity file to decrypt an encrypted archive.").StringVar(&keyFileArg)
+  restoreCmd.Flag("passphrase-file", "File with the passphrase to decrypt an encrypted archive.").StringVar(&passphraseFileArg)
+
   kingpin.CommandLine.Help = "A command-line minecraft config tool."
 
 }
@@ -130,6 +243,8 @@ func main() {
     modifyServerConfig.FullCommand(): doModifyServerConfig,
     archiveAndPublishCmd.FullCommand(): doArchiveAndPublish,
     queryCmd.FullCommand(): doQuery,
+    restoreCmd.FullCommand(): doRestore,
+    statusCmd.FullCommand(): doStatus,
   }
 
   configureLogs()
@@ -176,6 +291,46 @@ func main() {
     sess.Config.Region = aws.String(awsRegionArg)
   }
 
+  lib.SetS3Endpoint(lib.S3Endpoint{
+    URL: s3EndpointArg,
+    PathStyle: s3PathStyleArg,
+    DisableSSL: s3DisableSSLArg,
+  })
+  if s3EndpointArg != "" {
+    f["s3Endpoint"] = lib.GetS3Endpoint().String()
+  }
+
+  archiveEncryption, err = lib.NewEncryption(encryptToArg, keyFileArg, passphraseFileArg)
+  if err != nil {
+    log.Fatal(f, "Controller starting up: Can't load archive encryption keys.", err)
+  }
+  if archiveEncryption.Encrypts() {
+    f["encrypted"] = true
+  }
+  // Only a continuous controller is still around to retry what it spools.
+  if command == archiveAndPublishCmd.FullCommand() && continuousArchiveArg && spoolLimitArg > 0 {
+    archiveSpool, err = lib.OpenSpool(spoolDirArg, int64(spoolLimitArg))
+    if err != nil {
+      log.Fatal(f, "Controller starting up: Can't open the spool directory.", err)
+    }
+  }
+  snapshotSchedules, err = lib.ParseSnapshotSchedules(scheduleArg)
+  if err != nil {
+    log.Fatal(f, "Controller starting up: Bad snapshot schedule.", err)
+  }
+  for _, q := range quietHoursArg {
+    qh, err := lib.ParseQuietHours(q)
+    if err != nil {
+      log.Fatal(f, "Controller starting up: Bad quiet hours.", err)
+    }
+    quietHours = append(quietHours, qh)
+  }
+  if command == archiveAndPublishCmd.FullCommand() && continuousArchiveArg {
+    if err = checkContinuousDelays(); err != nil {
+      log.Fatal(f, "Controller starting up: Bad delay.", err)
+    }
+  }
+
   // TODO: Should we just get the Server Variables from ECS?
   // ie. mclib.GetServerByName()
 
@@ -256,8 +411,81 @@ func main() {
 // Command Implementations
 //
 
+// One command from the arguments, a batch from stdin or a file,
+// otherwise a prompt.
 func doQuery(server *mclib.Server) {
-  lib.RconLoop(server.PublicServerIp, server.RconPort, server.RconPassword)
+  f := server.LogFields()
+  f["operation"] = "Query"
+  if len(queryArg) == 0 && !queryStdinArg && queryFileArg == "" {
+    if err := lib.RconLoop(server.PublicServerIp, server.RconPort, server.RconPassword); err != nil {
+      log.Fatal(f, "Couldn't talk to the server.", err)
+    }
+    return
+  }
+  if len(queryArg) > 0 && (queryStdinArg || queryFileArg != "") || (queryStdinArg && queryFileArg != "") {
+    log.Fatal(f, "Query takes one of a command, --stdin or --file.", fmt.Errorf("Too many sources of commands."))
+  }
+
+  rcon, err := lib.NewRconClient(server.PublicServerIp, server.RconPort, server.RconPassword)
+  if err != nil { log.Fatal(f, "Couldn't connect to the server.", err) }
+  defer rcon.Close()
+  switch {
+  case queryStdinArg:
+    err = lib.RconBatch(rcon, os.Stdin, os.Stdout, queryOutputArg)
+  case queryFileArg != "":
+    var file *os.File
+    if file, err = os.Open(queryFileArg); err == nil {
+      err = lib.RconBatch(rcon, file, os.Stdout, queryOutputArg)
+      file.Close()
+    }
+  default:
+    err = lib.RconCommand(rcon, strings.Join(queryArg, " "), os.Stdout, queryOutputArg)
+  }
+  if err != nil {
+    rcon.Close()
+    log.Fatal(f, "Query failed.", err)
+  }
+}
+
+func doRestore(server *mclib.Server) {
+  f := server.LogFields()
+  f["uri"] = restoreURIArg
+  f["serverDir"] = server.ServerDirectory
+  f["operation"] = "Restore"
+  result, err := lib.RestoreArchive(restoreURIArg, server.PublicServerIp, server.RconPort, server.ServerDirectory, server.AWSSession, archiveEncryption)
+  if err != nil {
+    f["result"] = "Failure"
+    log.Fatal(f, "Failed to restore archive.", err)
+  }
+  f["result"] = "Success"
+  f["files"] = result.Files
+  f["previousDir"] = result.PreviousDirectory
+  log.Info(f, "Restore successful.")
+}
+
+// Uploads waiting in the spool, oldest first.
+func doStatus(*mclib.Server) {
+  f := logrus.Fields{"operation": "Status", "spoolDir": spoolDirArg}
+  spool, err := lib.OpenSpool(spoolDirArg, 0)
+  if err != nil { log.Fatal(f, "Can't open the spool directory.", err) }
+  entries, err := spool.Entries()
+  if err != nil { log.Fatal(f, "Can't read the spool.", err) }
+
+  var total int64
+  w := tabwriter.NewWriter(os.Stdout, 4, 8, 3, ' ', 0)
+  fmt.Printf("%sSpool %s: %d waiting.%s\n", lib.TitleColor, spoolDirArg, len(entries), lib.ResetColor)
+  if len(entries) > 0 {
+    fmt.Fprintf(w, "%sURI\tSize\tSpooled\tAttempts\tLastAttempt\tLastError%s\n", lib.TitleColor, lib.ResetColor)
+    for _, e := range entries {
+      last := "----"
+      if !e.LastAttempt.IsZero() { last = e.LastAttempt.Local().Format(time.RFC1123) }
+      fmt.Fprintf(w, "%s%s\t%d\t%s\t%d\t%s\t%s%s\n", lib.NullColor,
+        e.URI, e.Size, e.Created.Local().Format(time.RFC1123), e.Attempts, last, e.LastError, lib.ResetColor)
+      total += e.Size
+    }
+    w.Flush()
+  }
+  fmt.Printf("Total: %s\n", units.Base2Bytes(total).String())
 }
 
 func doListServerConfig(*mclib.Server) {
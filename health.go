@@ -0,0 +1,185 @@
+package main
+
+import(
+  "encoding/json"
+  "fmt"
+  "net/http"
+  "sync"
+  "time"
+  "craft-config/version"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+// What the controller has seen lately, served over HTTP when --http-addr is set:
+//   /healthz  200 if RCON answered the last user check and, when snapshots are
+//             due, the last successful one is within --health-slo. 503 otherwise.
+//   /status   The same, and more, as JSON.
+//   /metrics  Prometheus metrics, see metrics.go.
+// Snapshots are only due while there are users, or after one fails or is
+// spooled, so an idle server stays healthy.
+
+type snapshotStatus struct {
+  Type string `json:"type"`
+  Time time.Time `json:"time"`
+  Result string `json:"result"`
+  URI string `json:"uri,omitempty"`
+  Error string `json:"error,omitempty"`
+}
+
+type controllerState struct {
+  mu sync.Mutex
+  started time.Time
+  last *snapshotStatus
+  lastSuccess time.Time
+  byType map[string]snapshotStatus
+  successByType map[string]time.Time
+  users int
+  usersKnown bool
+  userCheck time.Time
+  rconError string
+}
+
+var controller = newControllerState()
+
+func newControllerState() (*controllerState) {
+  return &controllerState{
+    started: time.Now(),
+    byType: make(map[string]snapshotStatus),
+    successByType: make(map[string]time.Time),
+  }
+}
+
+// result is Success, Spooled or Failure. A spooled snapshot isn't
+// stored yet, so it doesn't count as a success.
+func (c *controllerState) snapshotDone(aType mclib.ArchiveType, result, uri string, err error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  ss := snapshotStatus{Type: aType.String(), Time: time.Now(), Result: result, URI: uri}
+  if err != nil { ss.Error = err.Error() }
+  c.last = &ss
+  c.byType[ss.Type] = ss
+  if result == "Success" {
+    c.lastSuccess = ss.Time
+    c.successByType[ss.Type] = ss.Time
+  }
+}
+
+// Time since each type of snapshot last succeeded, "<never>" for Server
+// and World snapshots that haven't yet.
+func (c *controllerState) sinceSuccess(now time.Time) (map[string]string) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  since := map[string]string{
+    mclib.ServerSnapshot.String(): "<never>",
+    mclib.WorldSnapshot.String(): "<never>",
+  }
+  for t, at := range c.successByType {
+    since[t] = now.Sub(at).Round(time.Second).String()
+  }
+  return since
+}
+
+func (c *controllerState) usersChecked(users int, err error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.userCheck = time.Now()
+  if err != nil {
+    c.rconError = err.Error()
+    return
+  }
+  c.rconError = ""
+  c.users = users
+  c.usersKnown = true
+}
+
+type statusReport struct {
+  Healthy bool `json:"healthy"`
+  Problems []string `json:"problems,omitempty"`
+  ControllerVersion string `json:"controllerVersion"`
+  ControllerUptime string `json:"controllerUptime"`
+  ServerUptime string `json:"serverUptime"`
+  CraftType string `json:"craftType"`
+  RconReachable bool `json:"rconReachable"`
+  RconError string `json:"rconError,omitempty"`
+  Users *int `json:"users"`
+  UserCheck time.Time `json:"userCheck"`
+  LastSnapshot *snapshotStatus `json:"lastSnapshot"`
+  LastSuccess time.Time `json:"lastSuccess"`
+  Snapshots map[string]snapshotStatus `json:"snapshots"`
+}
+
+// Health is judged against slo at now.
+func (c *controllerState) report(slo time.Duration, now time.Time) (statusReport) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  r := statusReport{
+    ControllerVersion: version.Version.String(),
+    ControllerUptime: now.Sub(c.started).Round(time.Second).String(),
+    RconReachable: !c.userCheck.IsZero() && c.rconError == "",
+    RconError: c.rconError,
+    UserCheck: c.userCheck,
+    LastSuccess: c.lastSuccess,
+    Snapshots: make(map[string]snapshotStatus, len(c.byType)),
+  }
+  if c.usersKnown {
+    users := c.users
+    r.Users = &users
+  }
+  if c.last != nil {
+    last := *c.last
+    r.LastSnapshot = &last
+  }
+  for t, ss := range c.byType { r.Snapshots[t] = ss }
+
+  if !r.RconReachable { r.Problems = append(r.Problems, "RCON isn't reachable.") }
+  due := (c.usersKnown && c.users > 0) || (c.last != nil && c.last.Result != "Success")
+  since := c.lastSuccess
+  if since.IsZero() { since = c.started }
+  if due && slo > 0 && now.Sub(since) > slo {
+    r.Problems = append(r.Problems, fmt.Sprintf("No successful snapshot in %s.", now.Sub(since).Round(time.Second)))
+  }
+  r.Healthy = len(r.Problems) == 0
+  return r
+}
+
+func statusHandler(s *mclib.Server) (*http.ServeMux) {
+  mux := http.NewServeMux()
+  mux.HandleFunc("/healthz", func(w http.ResponseWriter, req *http.Request) {
+    r := controller.report(healthSLOArg, time.Now())
+    w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    if !r.Healthy {
+      w.WriteHeader(http.StatusServiceUnavailable)
+      for _, p := range r.Problems { fmt.Fprintln(w, p) }
+      return
+    }
+    fmt.Fprintln(w, "ok")
+  })
+  mux.HandleFunc("/status", func(w http.ResponseWriter, req *http.Request) {
+    r := controller.report(healthSLOArg, time.Now())
+    r.ServerUptime = s.UptimeString()
+    r.CraftType = s.CraftType()
+    w.Header().Set("Content-Type", "application/json")
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    enc.Encode(r)
+  })
+  if metrics != nil { mux.Handle("/metrics", metrics.handler()) }
+  return mux
+}
+
+// Serve /healthz, /status and /metrics on httpAddrArg until the returned server is closed.
+func startStatusServer(s *mclib.Server) (*http.Server) {
+  f := s.LogFields()
+  f["operation"] = "StatusServer"
+  f["httpAddr"] = httpAddrArg
+  srv := &http.Server{Addr: httpAddrArg, Handler: statusHandler(s)}
+  go func() {
+    if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+      log.Error(f, "Status server failed.", err)
+    }
+  }()
+  log.Info(f, "Serving health and status.")
+  return srv
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+  "encoding/json"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+  "github.com/stretchr/testify/assert"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+func TestControllerHealth(t *testing.T) {
+  c := newControllerState()
+  now := c.started.Add(time.Hour)
+
+  // Nothing heard from RCON yet.
+  assert.False(t, c.report(time.Minute, now).Healthy)
+
+  // Idle servers don't need snapshots.
+  c.usersChecked(0, nil)
+  assert.True(t, c.report(time.Minute, now).Healthy)
+
+  c.usersChecked(2, nil)
+  r := c.report(time.Minute, now)
+  assert.False(t, r.Healthy)
+  assert.Len(t, r.Problems, 1)
+
+  c.snapshotDone(mclib.WorldSnapshot, "Success", "file:///a/1.zip", nil)
+  r = c.report(time.Minute, time.Now())
+  assert.True(t, r.Healthy)
+  assert.Equal(t, 2, *r.Users)
+  assert.Equal(t, "file:///a/1.zip", r.LastSnapshot.URI)
+  since := c.sinceSuccess(r.LastSuccess.Add(90 * time.Second))
+  assert.Equal(t, "1m30s", since[mclib.WorldSnapshot.String()])
+  assert.Equal(t, "<never>", since[mclib.ServerSnapshot.String()])
+
+  // Spooled isn't stored yet.
+  later := r.LastSuccess.Add(2 * time.Minute)
+  c.snapshotDone(mclib.WorldSnapshot, "Spooled", "file:///a/2.zip", nil)
+  r = c.report(time.Minute, later)
+  assert.False(t, r.Healthy)
+  assert.Equal(t, "Spooled", r.LastSnapshot.Result)
+  since = c.sinceSuccess(later)
+  assert.Equal(t, "2m0s", since[mclib.WorldSnapshot.String()])
+
+  // A failed user check keeps the last count.
+  c.usersChecked(0, fmt.Errorf("Connection refused."))
+  r = c.report(time.Minute, time.Now())
+  assert.False(t, r.Healthy)
+  assert.False(t, r.RconReachable)
+  assert.Equal(t, 2, *r.Users)
+}
+
+func TestStatusHandler(t *testing.T) {
+  saved := controller
+  defer func() { controller = saved }()
+  controller = newControllerState()
+  controller.usersChecked(1, nil)
+  controller.snapshotDone(mclib.ServerSnapshot, "Failure", "", fmt.Errorf("Disk full."))
+
+  srv := httptest.NewServer(statusHandler(&mclib.Server{}))
+  defer srv.Close()
+
+  resp, err := http.Get(srv.URL + "/healthz")
+  assert.NoError(t, err)
+  resp.Body.Close()
+  assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+  resp, err = http.Get(srv.URL + "/status")
+  assert.NoError(t, err)
+  defer resp.Body.Close()
+  r := statusReport{}
+  assert.NoError(t, json.NewDecoder(resp.Body).Decode(&r))
+  assert.Equal(t, "Failure", r.LastSnapshot.Result)
+  assert.Equal(t, "Disk full.", r.Snapshots[mclib.ServerSnapshot.String()].Error)
+  assert.Equal(t, 1, *r.Users)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+  saved := metrics
+  defer func() { metrics = saved }()
+  s := &mclib.Server{User: "testuser", Name: "testserver", ClusterName: "testcluster"}
+  metrics = newControllerMetrics(s)
+  metrics.snapshotDone(mclib.WorldSnapshot, "Success", 2048, 2 * time.Second)
+  metrics.snapshotDone(mclib.WorldSnapshot, "Failure", 0, time.Second)
+  metrics.snapshotDone(mclib.ServerSnapshot, "Spooled", 4096, time.Second)
+  metrics.usersChecked(3)
+
+  srv := httptest.NewServer(statusHandler(s))
+  defer srv.Close()
+  resp, err := http.Get(srv.URL + "/metrics")
+  assert.NoError(t, err)
+  defer resp.Body.Close()
+  b, err := ioutil.ReadAll(resp.Body)
+  assert.NoError(t, err)
+  body := string(b)
+  assert.Contains(t, body, `craft_snapshots_total{cluster="testcluster",name="testserver",result="Success",type="WorldSnapshot",user="testuser"} 1`)
+  assert.Contains(t, body, `craft_snapshots_total{cluster="testcluster",name="testserver",result="Failure",type="WorldSnapshot",user="testuser"} 1`)
+  assert.Contains(t, body, `craft_upload_bytes_per_second{cluster="testcluster",name="testserver",type="WorldSnapshot",user="testuser"} 1024`)
+  assert.Contains(t, body, `craft_users{cluster="testcluster",name="testserver",user="testuser"} 3`)
+  // Spooled isn't stored yet.
+  assert.Contains(t, body, `craft_snapshot_last_success_timestamp_seconds{cluster="testcluster",name="testserver",type="WorldSnapshot",user="testuser"}`)
+  assert.NotContains(t, body, `craft_snapshot_last_success_timestamp_seconds{cluster="testcluster",name="testserver",type="ServerSnapshot",user="testuser"}`)
+}
@@ -68,47 +68,112 @@ func doArchiveServer(sess *session.Session) (err error) {
   }
 
 
-  var resp *mclib.PublishedArchiveResponse
-  switch archiveType {
-  case mclib.ServerSnapshot:
-    resp, err = s.TakeServerSnapshot()
-  case mclib.WorldSnapshot: 
-    resp, err = s.TakeWorldSnapshot()
-  case mclib.MiscSnapshot:
-    resp, err = s.TakeSnapshotWithFiles(files)
-  default: 
+  if archiveType == mclib.ServerSnapshot || archiveType == mclib.WorldSnapshot {
+    if !noRcon {
+      if err = s.NewRcon(); err != nil {
+        return fmt.Errorf("Couldn't get an rcon connection, use rcon to toggle it off (UNSAFE): %s", err)
+      }
+    }
+  } else if archiveType != mclib.MiscSnapshot {
     return fmt.Errorf("Error with incorrect archive type: %s", archiveType.String())
   }
 
+  enc, err := encryption()
+  if err != nil { return err }
+  store, err := l.NewArchiveStore(bucketName, sess)
+  if err != nil { return err }
+  result, err := l.TakeSnapshot(s, archiveType, store, l.SnapshotOptions{
+    Files: files,
+    Incremental: incrementalArg,
+    Exclude: excludeArg,
+    Format: l.ArchiveFormat(formatArg),
+    Encryption: enc,
+  })
   if err == nil {
-    version := "----"
-    if r := resp.PutObjectOutput.VersionId; r != nil { version = *r }
-    etag := "----"
-    if e := resp.PutObjectOutput.ETag; e != nil { etag = *e }
-    w := tabwriter.NewWriter(os.Stdout, 4, 8, 3, ' ', 0)
-    fmt.Printf("%s%sArchive Response.%s\n", l.TitleColor, time.Now().Local().Format(time.RFC1123), l.ResetColor)
-    fmt.Fprintf(w, "Bucket\tArchiveFile\tVersion\tEtag%s\n", l.TitleColor, l.ResetColor)
-    fmt.Fprintf(w, "%s%s\t%s\t%s\t%s%s\n", l.NullColor,
-      resp.BucketName, resp.ArchiveFilename, version, etag, l.ResetColor)
-    w.Flush()
-    fmt.Printf("Path: %s\n", resp.Key)
-    }
+    printStoredObject(result.Object, result.URI)
+  }
+  return err
+}
 
+func doDiffArchive(sess *session.Session) (error) {
+  enc, err := encryption()
+  if err != nil { return err }
+  diff, err := l.DiffArchives(diffFromArg, diffToArg, sess, enc)
+  if err != nil { return err }
 
-  return err
+  fmt.Printf("%sFrom: %s%s\n", l.TitleColor, diff.A, l.ResetColor)
+  fmt.Printf("%sTo:   %s%s\n", l.TitleColor, diff.B, l.ResetColor)
+  fmt.Printf("%d added, %d removed, %d modified.\n", 
+    diff.Count(l.FileAdded), diff.Count(l.FileRemoved), diff.Count(l.FileModified))
+  if len(diff.Files) == 0 { return nil }
+
+  w := tabwriter.NewWriter(os.Stdout, 4, 8, 3, ' ', 0)
+  fmt.Fprintf(w, "%sChange\tPath\tOldSize\tNewSize\tChunks%s\n", l.TitleColor, l.ResetColor)
+  for _, fd := range diff.Files {
+    chunks := "----"
+    if fd.IsRegion { chunks = fmt.Sprintf("%d", fd.ChangedChunks) }
+    fmt.Fprintf(w, "%s%s\t%s\t%d\t%d\t%s%s\n", l.NullColor, 
+      fd.Change, fd.Path, fd.OldSize, fd.NewSize, chunks, l.ResetColor)
+  }
+  w.Flush()
+  return nil
+}
+
+// Keys from the encryption flags, nil if there weren't any.
+func encryption() (*l.Encryption, error) {
+  return l.NewEncryption(encryptToArg, keyFileArg, passphraseFileArg)
+}
 
+func printStoredObject(obj *l.StoredObject, uri string) {
+  version := "----"
+  if obj.VersionId != "" { version = obj.VersionId }
+  etag := "----"
+  if obj.ETag != "" { etag = obj.ETag }
+  w := tabwriter.NewWriter(os.Stdout, 4, 8, 3, ' ', 0)
+  fmt.Printf("%s%s Archive Response.%s\n", l.TitleColor, time.Now().Local().Format(time.RFC1123), l.ResetColor)
+  fmt.Fprintf(w, "%sKey\tVersion\tEtag%s\n", l.TitleColor, l.ResetColor)
+  fmt.Fprintf(w, "%s%s\t%s\t%s%s\n", l.NullColor, obj.Key, version, etag, l.ResetColor)
+  w.Flush()
+  fmt.Printf("URI: %s\n", uri)
 }
 
 func doPublishArchive(sess *session.Session) (error) {
-  resp, err := mclib.PublishArchive(archiveFileNameArg, bucketNameArg, userNameArg, sess)
+  store, err := l.NewArchiveStore(bucketNameArg, sess)
+  if err != nil { return err }
+  enc, err := encryption()
+  if err != nil { return err }
+  file, err := os.Open(archiveFileNameArg)
+  if err != nil { return err }
+  defer file.Close()
+
+  ext, err := l.FileArchiveExt(archiveFileNameArg)
+  if err != nil { return err }
+  archiveType := mclib.ArchiveTypeFrom(archiveTypeArg)
+  key := l.ArchiveKey(userNameArg, serverNameArg, archiveType, time.Now(), ext)
+  obj, key, err := l.PutEncrypted(store, key, file, enc)
   if err == nil {
-    fmt.Printf("Published archive to: %s\n", resp.URI())
+    printStoredObject(obj, store.URI(key))
   }
   return err
 }
 
 func doGetArchive(sess *session.Session) (error) {
-  fmt.Printf("Not implemented yet.")
+  rp, err := mclib.NewPort(rconPortArg)
+  if err != nil { rp = defaultRconPort }
+
+  enc, err := encryption()
+  if err != nil { return err }
+
+  fmt.Printf("%sRestoring archive:%s %s\n", l.TitleColor, l.ResetColor, archiveURIArg)
+  result, err := l.RestoreArchive(archiveURIArg, serverIpArg, rp, serverDirectoryNameArg, sess, enc)
+  if err != nil { return err }
+
+  w := tabwriter.NewWriter(os.Stdout, 4, 8, 3, ' ', 0)
+  fmt.Fprintf(w, "%sServerDir\tFiles\tPreviousDir%s\n", l.TitleColor, l.ResetColor)
+  previous := "----"
+  if result.PreviousDirectory != "" { previous = result.PreviousDirectory }
+  fmt.Fprintf(w, "%s%s\t%d\t%s%s\n", l.NullColor, result.ServerDirectory, result.Files, previous, l.ResetColor)
+  w.Flush()
   return nil
 }
 
@@ -116,30 +181,92 @@ func doListArchive(sess *session.Session) (err error) {
   userName := userNameArg
   bucketName := bucketNameArg
 
-  am, err := mclib.GetArchives(userName, bucketName, sess )
+  store, err := l.NewArchiveStore(bucketName, sess)
+  if err != nil { return err }
+  al, err := l.ListArchives(store, userName)
   if err != nil { return err }
 
-  var al []mclib.Archive
-  if archiveTypeArg == NoArchiveTypeArg {
-    al = make([]mclib.Archive,0)
-    for _, serverMap := range am {
-      for _, archiveList := range serverMap {
-        al = append(al, archiveList...)
-      }
-    }
-  } else {
+  if archiveTypeArg != NoArchiveTypeArg {
     t := mclib.ArchiveTypeFrom(archiveTypeArg)
-    al = am.GetArchives(userName, t)
+    typed := make([]l.ArchiveEntry, 0)
+    for _, a := range al {
+      if a.Type == t { typed = append(typed, a) }
+    }
+    al = typed
   }
-  sort.Sort(mclib.ByLastMod(al))
+  sort.Sort(l.ByLastMod(al))
 
   w := tabwriter.NewWriter(os.Stdout, 4, 8, 3, ' ', 0)
   fmt.Printf("%s%s: %d Archives.%s\n", l.TitleColor, time.Now().Local().Format(time.RFC1123), len(al), l.ResetColor)
-  fmt.Fprintf(w, "%sUser\tServer\tType\tBucket\tLastMod\tS3Key%s\n", l.TitleColor, l.ResetColor)
+  fmt.Fprintf(w, "%sUser\tServer\tType\tFormat\tEncrypted\tLastMod\tSize\tURI%s\n", l.TitleColor, l.ResetColor)
   for _, a := range al {
-    fmt.Fprintf(w, "%s%s\t%s\t%s\t%s\t%s\t%s%s\n", l.NullColor, 
-      a.UserName, a.ServerName, a.Type.String(), a.Bucket, a.LastMod(), a.S3Key(), l.ResetColor)
+    fmt.Fprintf(w, "%s%s\t%s\t%s\t%s\t%t\t%s\t%d\t%s%s\n", l.NullColor, 
+      a.UserName, a.ServerName, a.Type.String(), a.Format(), a.Encrypted(), a.LastMod.Local().Format(time.RFC1123), a.Size, a.URI, l.ResetColor)
   }
   w.Flush()
   return nil
 }
+
+func doPruneArchive(sess *session.Session) (err error) {
+  policy := l.RetentionPolicy{
+    KeepLast: keepLastArg,
+    Daily: keepDailyArg,
+    Weekly: keepWeeklyArg,
+    Monthly: keepMonthlyArg,
+  }
+
+  store, err := l.NewArchiveStore(bucketNameArg, sess)
+  if err != nil { return err }
+  al, err := l.ListArchives(store, userNameArg)
+  if err != nil { return err }
+
+  decisions, err := l.PruneArchives(store, al, policy, dryRunArg)
+  if decisions == nil { return err }
+
+  pruned := 0
+  for _, d := range decisions {
+    if !d.Keep { pruned++ }
+  }
+  action := "Pruned"
+  if dryRunArg { action = "Would prune" }
+
+  w := tabwriter.NewWriter(os.Stdout, 4, 8, 3, ' ', 0)
+  fmt.Printf("%s%s: %s %d of %d Archives (%s).%s\n", l.TitleColor, time.Now().Local().Format(time.RFC1123), 
+    action, pruned, len(decisions), policy, l.ResetColor)
+  fmt.Fprintf(w, "%sUser\tServer\tType\tLastMod\tAction\tReason\tURI%s\n", l.TitleColor, l.ResetColor)
+  for _, d := range decisions {
+    color, keep := l.SuccessColor, "keep"
+    if !d.Keep { color, keep = l.FailColor, "prune" }
+    a := d.Archive
+    fmt.Fprintf(w, "%s%s\t%s\t%s\t%s\t%s\t%s\t%s%s\n", color, 
+      a.UserName, a.ServerName, a.Type.String(), a.LastMod.Local().Format(time.RFC1123), keep, d.Reason, a.URI, l.ResetColor)
+  }
+  w.Flush()
+  return err
+}
+
+func doVerifyArchive(sess *session.Session) (error) {
+  enc, err := encryption()
+  if err != nil { return err }
+  report, err := l.VerifyArchive(archiveURIArg, sess, enc)
+  if err != nil { return err }
+
+  m := report.Manifest
+  w := tabwriter.NewWriter(os.Stdout, 4, 8, 3, ' ', 0)
+  fmt.Printf("%sManifest for: %s%s\n", l.TitleColor, report.URI, l.ResetColor)
+  fmt.Fprintf(w, "%sUser\tServer\tType\tCreated\tCraftType\tMCVersion\tLevel\tFiles%s\n", l.TitleColor, l.ResetColor)
+  fmt.Fprintf(w, "%s%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d%s\n", l.NullColor, 
+    m.UserName, m.ServerName, m.Type, m.Created.Local().Format(time.RFC1123), 
+    m.CraftType, m.MinecraftVersion, m.LevelName, len(m.Files), l.ResetColor)
+  w.Flush()
+  fmt.Printf("Controller: %s\n", m.ControllerVersion)
+
+  if report.OK() {
+    fmt.Printf("%sVerified %d files.%s\n", l.SuccessColor, report.Checked, l.ResetColor)
+    return nil
+  }
+  for _, p := range report.Problems {
+    fmt.Printf("%s%s%s\n", l.FailColor, p, l.ResetColor)
+  }
+  return fmt.Errorf("Archive failed verification: %d problems in %d files", len(report.Problems), report.Checked)
+}
@@ -2,6 +2,7 @@ package interactive
 
 import(
   "fmt"
+  "craft-config/lib"
   "github.com/aws/aws-sdk-go/aws/session"
   "github.com/jdrivas/awslib"
 )
@@ -15,3 +16,19 @@ func doAwsAccount(sess *session.Session) (error) {
 
   return nil
 }
+
+func doS3Endpoint() (error) {
+  switch s3EndpointArg {
+  case "":
+  case "aws":
+    lib.SetS3Endpoint(lib.S3Endpoint{})
+  default:
+    lib.SetS3Endpoint(lib.S3Endpoint{
+      URL: s3EndpointArg,
+      PathStyle: s3PathStyleArg,
+      DisableSSL: s3DisableSSLArg,
+    })
+  }
+  fmt.Printf("S3 endpoint: %s\n", lib.GetS3Endpoint())
+  return nil
+}
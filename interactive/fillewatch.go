@@ -4,15 +4,20 @@ import(
   "path/filepath"
   "fmt"
   "os"
+  "craft-config/lib"
   "github.com/fsnotify/fsnotify"
   "github.com/Sirupsen/logrus"
 )
 
-// TODO: Either add a .craftignore file
-// or at least don't look at .git.
+const watchBaseDir = "."
+
+// Files excluded by .craftignore (and --exclude) are neither watched nor reported.
 func doWatchEventsStart() (err error) {
   if watcher != nil { return fmt.Errorf("Watcher already being used.") }
 
+  rules, err := lib.LoadIgnoreRules(watchBaseDir, excludeArg)
+  if err != nil { return err }
+
   watcher, err = fsnotify.NewWatcher()
   if err != nil { return fmt.Errorf("Couldn't create a notifycation watcher: %s", err) }
 
@@ -21,17 +26,15 @@ func doWatchEventsStart() (err error) {
     for {
       select {
       case event := <-watcher.Events:
+        isDir := false
+        if fInfo, err := os.Stat(event.Name); err == nil { isDir = fInfo.IsDir() }
+        if rel, err := filepath.Rel(watchBaseDir, event.Name); err == nil && rules.Ignored(rel, isDir) {
+          continue
+        }
         log.Info(logrus.Fields{"event": event}, "File Event")
-        if event.Op & fsnotify.Create == fsnotify.Create { // If we add a dir, watch it.
-          file, err := os.Open(event.Name)
-          f := logrus.Fields{"file": event.Name}
-          if err != nil {log.Error(f, "Can't open new file.", err)}
-          fInfo, err := file.Stat()
-          if err != nil {log.Error(f, "Can't state new file.", err)}
-          if fInfo.IsDir() {
-            log.Info(f, "Adding directory to watch.")
-            watcher.Add(event.Name)
-          }
+        if event.Op & fsnotify.Create == fsnotify.Create && isDir { // If we add a dir, watch it.
+          log.Info(logrus.Fields{"file": event.Name}, "Adding directory to watch.")
+          addWatchTree(event.Name, watcher, rules)
         }
       case err := <-watcher.Errors:
         log.Error(nil, "File watch.", err)
@@ -41,26 +44,25 @@ func doWatchEventsStart() (err error) {
       } 
     }
   }()
-  addWatchTree(".", watcher)
+  addWatchTree(watchBaseDir, watcher, rules)
   return err
 }
 
-// add the directories starting at the base to a watcher.
-func addWatchTree(baseDir string, w *fsnotify.Watcher) (err error) {
+// add the directories starting at the base to a watcher, skipping the excluded ones.
+func addWatchTree(baseDir string, w *fsnotify.Watcher, rules *lib.IgnoreRules) (err error) {
 
   f := logrus.Fields{ "watchDir": baseDir, "file": ""}
   log.Debug(f, "Adding files to directory.")
   err = filepath.Walk(baseDir, func(path string, info os.FileInfo, err error) (error) {
-    // fds["file"] = path
-    // ctx.Debug("Adding a file.")
     f["file"] = path
-    log.Debug(f, "Adding a file.")
     if err != nil { return err }
-    if info.IsDir() {
-      // log.Infof("Adding %s to watch list.", path)
-      err = w.Add(path)
+    if !info.IsDir() { return nil }
+    if rel, rerr := filepath.Rel(watchBaseDir, path); rerr == nil && rules.Ignored(rel, true) {
+      log.Debug(f, "Excluding directory from watch.")
+      return filepath.SkipDir
     }
-    return err
+    log.Debug(f, "Adding a directory.")
+    return w.Add(path)
   })
   return err
 }
@@ -0,0 +1,101 @@
+//go:build integration
+// +build integration
+
+package interactive
+
+// Runs archive, publish and list against a real S3-compatible store:
+//   cd integration && docker-compose up -d minio
+//   go test -tags integration ./interactive/
+// CRAFT_TEST_S3_ENDPOINT, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+// point it somewhere else, the defaults match the minio service.
+
+import (
+  "bytes"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/aws/awserr"
+  "github.com/aws/aws-sdk-go/aws/credentials"
+  "github.com/aws/aws-sdk-go/aws/session"
+  "github.com/aws/aws-sdk-go/service/s3"
+  "github.com/stretchr/testify/assert"
+)
+
+const(
+  testS3Endpoint = "http://localhost:9000"
+  testS3Bucket = "craft-integration"
+)
+
+func envOr(key, def string) (string) {
+  if v := os.Getenv(key); v != "" { return v }
+  return def
+}
+
+// Run a command line as typed at the prompt, returning what it printed.
+func runCommand(t *testing.T, sess *session.Session, line string) (string) {
+  saved := os.Stdout
+  r, w, err := os.Pipe()
+  if !assert.NoError(t, err) { return "" }
+  os.Stdout = w
+  out := make(chan string)
+  go func() {
+    var b bytes.Buffer
+    io.Copy(&b, r)
+    out <- b.String()
+  }()
+  err = doICommand(line, sess)
+  w.Close()
+  os.Stdout = saved
+  printed := <-out
+  assert.NoError(t, err, line)
+  return printed
+}
+
+func TestS3CompatibleCommands(t *testing.T) {
+  endpoint := envOr("CRAFT_TEST_S3_ENDPOINT", testS3Endpoint)
+  sess, err := session.NewSession(&aws.Config{
+    Region: aws.String("us-east-1"),
+    Credentials: credentials.NewStaticCredentials(
+      envOr("AWS_ACCESS_KEY_ID", "minio"), envOr("AWS_SECRET_ACCESS_KEY", "minio123"), ""),
+  })
+  if !assert.NoError(t, err) { return }
+
+  // A bucket of our own each run.
+  bucket := fmt.Sprintf("%s-%d", testS3Bucket, time.Now().UnixNano())
+  client := s3.New(sess, &aws.Config{Endpoint: aws.String(endpoint), S3ForcePathStyle: aws.Bool(true)})
+  if _, err = client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+    if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != s3.ErrCodeBucketAlreadyOwnedByYou {
+      t.Fatalf("Can't create bucket %s at %s, is MinIO running? %s", bucket, endpoint, err)
+    }
+  }
+
+  dir, err := ioutil.TempDir("", "s3-integration")
+  assert.NoError(t, err)
+  defer os.RemoveAll(dir)
+  serverDir := filepath.Join(dir, "server")
+  assert.NoError(t, os.MkdirAll(filepath.Join(serverDir, "world", "region"), 0755))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "world", "level.dat"), []byte("level"), 0644))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "server.properties"), []byte("level-name=world\n"), 0644))
+  archiveFile := filepath.Join(dir, "misc.zip")
+  assert.NoError(t, ioutil.WriteFile(archiveFile, []byte("published"), 0644))
+
+  runCommand(t, sess, "s3-endpoint " + endpoint + " --path-style --disable-ssl")
+  defer runCommand(t, sess, "s3-endpoint aws")
+  store := "s3://" + bucket
+
+  out := runCommand(t, sess, "archive server WorldSnapshot testuser testserver --no-rcon --server-dir " + serverDir + " --bucket " + store)
+  assert.Contains(t, out, store + "/testuser/testserver/WorldSnapshot/")
+
+  out = runCommand(t, sess, "archive publish testuser " + archiveFile + " " + store + " --server testserver --type MiscSnapshot")
+  assert.Contains(t, out, store + "/testuser/testserver/MiscSnapshot/")
+
+  out = runCommand(t, sess, "archive list testuser " + NoArchiveTypeArg + " " + store)
+  assert.Contains(t, out, "2 Archives.")
+  assert.Contains(t, out, store + "/testuser/testserver/WorldSnapshot/")
+  assert.Contains(t, out, store + "/testuser/testserver/MiscSnapshot/")
+}
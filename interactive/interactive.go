@@ -42,6 +42,10 @@ var (
   exitCmd *kingpin.CmdClause
   quitCmd *kingpin.CmdClause
   awsAccountCmd *kingpin.CmdClause
+  s3EndpointCmd *kingpin.CmdClause
+  s3EndpointArg string
+  s3PathStyleArg bool
+  s3DisableSSLArg bool
   verboseCmd *kingpin.CmdClause
   versionCmd *kingpin.CmdClause
   logFormatCmd *kingpin.CmdClause
@@ -90,6 +94,11 @@ var (
   archivePublishCmd *kingpin.CmdClause
   archiveGetCmd *kingpin.CmdClause
   archiveListCmd *kingpin.CmdClause
+  archivePruneCmd *kingpin.CmdClause
+  archiveVerifyCmd *kingpin.CmdClause
+  archiveDiffCmd *kingpin.CmdClause
+  diffFromArg string
+  diffToArg string
 
   archiveURIArg string
   archiveTypeArg string
@@ -99,6 +108,17 @@ var (
   bucketNameArg string
   userNameArg string
   serverNameArg string
+  keepLastArg int
+  keepDailyArg int
+  keepWeeklyArg int
+  keepMonthlyArg int
+  dryRunArg bool
+  incrementalArg bool
+  excludeArg []string
+  formatArg string
+  encryptToArg []string
+  keyFileArg string
+  passphraseFileArg string
 
   // Watch file-system.
   watchCmd *kingpin.CmdClause
@@ -130,6 +150,10 @@ func init() {
   logFormatCmd = app.Command("log", "set the log format")
   logFormatCmd.Arg("format", "What format should we use").Default(defaultLogFormat).EnumVar(&logFormatArg, jsonLog, textLog)
   awsAccountCmd = app.Command("aws", "Display what we know about the conneciton to AWS.")
+  s3EndpointCmd = app.Command("s3-endpoint", "Set or show the endpoint for an S3-compatible archive store (MinIO, Ceph etc.).")
+  s3EndpointCmd.Arg("url", "Endpoint URL: http://minio:9000. Use 'aws' to go back to AWS.").StringVar(&s3EndpointArg)
+  s3EndpointCmd.Flag("path-style", "Use path-style addressing (endpoint/bucket/key).").BoolVar(&s3PathStyleArg)
+  s3EndpointCmd.Flag("disable-ssl", "Don't use SSL to talk to the endpoint.").BoolVar(&s3DisableSSLArg)
 
 
   // Query a server.
@@ -160,32 +184,70 @@ func init() {
   archiveServerCmd.Arg("user", "Username for the server for archiving").Required().StringVar(&userNameArg)
   archiveServerCmd.Arg("server", "Servername for the server for archiving").Required().StringVar(&serverNameArg)
   archiveServerCmd.Arg("archive-files", "list of files to archive.").StringsVar(&archiveFilesArg)
-  archiveServerCmd.Flag("bucket", "Name of S3 bucket to publish archive to.").Default(defaultArchiveBucket).StringVar(&bucketNameArg)
+  archiveServerCmd.Flag("bucket", "Archive store to publish to: bucket name, s3://bucket or file:///directory.").Default(defaultArchiveBucket).StringVar(&bucketNameArg)
   archiveServerCmd.Flag("archive-file-name", "Name of archive (zip) file to create.").Default(defaultArchiveFile).StringVar(&archiveFileNameArg)
   archiveServerCmd.Flag("server-dir", "Relative location of server.").Default(".").StringVar(&serverDirectoryNameArg)
   archiveServerCmd.Flag("server-ip", "Server IP or dns. Used to get an RCON connection.").Default(defaultServerIp).StringVar(&serverIpArg)
   archiveServerCmd.Flag("rcon-port", "Port on the server where RCON is listening.").Default("25575").StringVar(&rconPortArg)
   archiveServerCmd.Flag("rcon-pw", "Password for rcon connection.").Default("testing").StringVar(&rconPasswordArg)
+  archiveServerCmd.Flag("incremental", "Store content-addressed files and a manifest, only uploading what changed.").BoolVar(&incrementalArg)
+  archiveServerCmd.Flag("exclude", "Leave out files matching this .gitignore style pattern, in addition to .craftignore. Repeatable.").StringsVar(&excludeArg)
+  archiveServerCmd.Flag("format", "Archive format: " + strings.Join(lib.ArchiveFormats, ", ") + ".").Default(string(lib.DefaultFormat)).EnumVar(&formatArg, lib.ArchiveFormats...)
+  archiveServerCmd.Flag("encrypt-to", "Encrypt to this age public key (age1...). Repeatable.").StringsVar(&encryptToArg)
+  archiveServerCmd.Flag("key-file", "Encrypt to the keys in this age identity file.").StringVar(&keyFileArg)
+  archiveServerCmd.Flag("passphrase-file", "Encrypt with the passphrase in this file.").StringVar(&passphraseFileArg)
   archiveServerCmd.Flag("no-rcon","Don't try to connect to an RCON server for archiving. UNSAFE.").BoolVar(&noRcon)
 
-  archivePublishCmd = archiveCmd.Command("publish", "Publish an archive to S3.")
+  archivePublishCmd = archiveCmd.Command("publish", "Publish an archive to S3 or a local directory.")
   archivePublishCmd.Arg("user", "User of archive.").Required().StringVar(&userNameArg)
   archivePublishCmd.Arg("archive-file", "Name of archive file to pubilsh.").Default(defaultArchiveFile).StringVar(&archiveFileNameArg)
-  archivePublishCmd.Arg("bucket", "Name of S3 bucket to publish archive to.").Default(defaultArchiveBucket).StringVar(&bucketNameArg)
-
-  archiveGetCmd = archiveCmd.Command("get", "Retreive an archive from S3.")
-  archiveGetCmd.Arg("uri", "Fullly qualified URI for the archive.").Required().StringVar(&archiveURIArg)
+  archivePublishCmd.Arg("bucket", "Archive store to publish to: bucket name, s3://bucket or file:///directory.").Default(defaultArchiveBucket).StringVar(&bucketNameArg)
+  archivePublishCmd.Flag("server", "Server the archive belongs to.").Required().StringVar(&serverNameArg)
+  archivePublishCmd.Flag("type", "Type of the archive: ServerSnapshot, WorldSnapshot or MiscSnapshot.").Required().EnumVar(&archiveTypeArg,
+    mclib.ServerSnapshot.String(), mclib.WorldSnapshot.String(), mclib.MiscSnapshot.String())
+  archivePublishCmd.Flag("encrypt-to", "Encrypt to this age public key (age1...). Repeatable.").StringsVar(&encryptToArg)
+  archivePublishCmd.Flag("key-file", "Encrypt to the keys in this age identity file.").StringVar(&keyFileArg)
+  archivePublishCmd.Flag("passphrase-file", "Encrypt with the passphrase in this file.").StringVar(&passphraseFileArg)
+
+  archiveGetCmd = archiveCmd.Command("get", "Retreive an archive and restore it into a server directory.")
+  archiveGetCmd.Arg("uri", "Fullly qualified URI for the archive: s3://bucket/key or file:///path.").Required().StringVar(&archiveURIArg)
+  archiveGetCmd.Flag("server-dir", "Server directory to restore into.").Default(".").StringVar(&serverDirectoryNameArg)
+  archiveGetCmd.Flag("server-ip", "Server IP or dns. Used to check that the server is not running.").Default(defaultServerIp).StringVar(&serverIpArg)
+  archiveGetCmd.Flag("rcon-port", "Port on the server where RCON is listening.").Default("25575").StringVar(&rconPortArg)
+  archiveGetCmd.Flag("key-file", "age identity file to decrypt an encrypted archive.").StringVar(&keyFileArg)
+  archiveGetCmd.Flag("passphrase-file", "File with the passphrase to decrypt an encrypted archive.").StringVar(&passphraseFileArg)
   // archiveListCmd.Arg("bucket", "Only list archives of this type.").Default(defaultArchiveBucket).StringVar(&bucketNameArg)
 
   archiveListCmd = archiveCmd.Command("list", "List the archives in the bucket.")
   archiveListCmd.Arg("user", "User name for the archives.").Required().StringVar(&userNameArg)
   archiveListCmd.Arg("type", "Only list archives of this type.").Default(NoArchiveTypeArg).StringVar(&archiveTypeArg)
-  archiveListCmd.Arg("bucket", "Only list archives of this type.").Default(defaultArchiveBucket).StringVar(&bucketNameArg)
+  archiveListCmd.Arg("bucket", "Archive store to list: bucket name, s3://bucket or file:///directory.").Default(defaultArchiveBucket).StringVar(&bucketNameArg)
+
+  archivePruneCmd = archiveCmd.Command("prune", "Remove archives that fall outside of the retention policy.")
+  archivePruneCmd.Arg("user", "User name for the archives.").Required().StringVar(&userNameArg)
+  archivePruneCmd.Arg("bucket", "Archive store the archives are in: bucket name, s3://bucket or file:///directory.").Default(defaultArchiveBucket).StringVar(&bucketNameArg)
+  archivePruneCmd.Flag("keep-last", "Keep this many of the most recent archives.").Default("12").IntVar(&keepLastArg)
+  archivePruneCmd.Flag("keep-daily", "Keep the newest archive for this many days.").Default("7").IntVar(&keepDailyArg)
+  archivePruneCmd.Flag("keep-weekly", "Keep the newest archive for this many weeks.").Default("4").IntVar(&keepWeeklyArg)
+  archivePruneCmd.Flag("keep-monthly", "Keep the newest archive for this many months.").Default("6").IntVar(&keepMonthlyArg)
+  archivePruneCmd.Flag("dry-run", "Show what would be pruned without deleting anything.").BoolVar(&dryRunArg)
+
+  archiveVerifyCmd = archiveCmd.Command("verify", "Download an archive and check it against its manifest.")
+  archiveVerifyCmd.Arg("uri", "Fullly qualified URI for the archive: s3://bucket/key or file:///path.").Required().StringVar(&archiveURIArg)
+  archiveVerifyCmd.Flag("key-file", "age identity file to decrypt an encrypted archive.").StringVar(&keyFileArg)
+  archiveVerifyCmd.Flag("passphrase-file", "File with the passphrase to decrypt an encrypted archive.").StringVar(&passphraseFileArg)
+
+  archiveDiffCmd = archiveCmd.Command("diff", "Show the files added, removed and modified between two archives, or an archive and a server directory.")
+  archiveDiffCmd.Arg("a", "Archive URI (s3://bucket/key or file:///path) or server directory.").Required().StringVar(&diffFromArg)
+  archiveDiffCmd.Arg("b", "Archive URI (s3://bucket/key or file:///path) or server directory.").Default(".").StringVar(&diffToArg)
+  archiveDiffCmd.Flag("key-file", "age identity file to decrypt encrypted archives.").StringVar(&keyFileArg)
+  archiveDiffCmd.Flag("passphrase-file", "File with the passphrase to decrypt encrypted archives.").StringVar(&passphraseFileArg)
 
   // Watch
   watchCmd = app.Command("watch", "Watch the file system.")
   watchEventsCmd = watchCmd.Command("events", "Print out events.")
   watchEventsStartCmd = watchEventsCmd.Command("start", "Start watching events.")
+  watchEventsStartCmd.Flag("exclude", "Don't watch files matching this .gitignore style pattern, in addition to .craftignore. Repeatable.").StringsVar(&excludeArg)
   watchEventsStopCmd = watchEventsCmd.Command("stop", "Stop watching events.")
 
   configureLogs()
@@ -197,6 +259,15 @@ func doICommand(line string, sess *session.Session) (err error) {
   // Variables keep there values between parsings. This means that
   // slices of strings just grow. We reset them here.
   archiveFilesArg = []string{}
+  excludeArg = []string{}
+  dryRunArg = false
+  incrementalArg = false
+  encryptToArg = []string{}
+  keyFileArg = ""
+  passphraseFileArg = ""
+  s3EndpointArg = ""
+  s3PathStyleArg = false
+  s3DisableSSLArg = false
 
   // Prepare a line for parsing
   line = strings.TrimRight(line, "\n")
@@ -220,6 +291,7 @@ func doICommand(line string, sess *session.Session) (err error) {
       case exitCmd.FullCommand(): err = doQuit()
       case quitCmd.FullCommand(): err = doQuit()
       case awsAccountCmd.FullCommand(): err = doAwsAccount(sess)
+      case s3EndpointCmd.FullCommand(): err = doS3Endpoint()
       case rconCmd.FullCommand(): err = doRcon()
       case queryCmd.FullCommand(): err = doQuery()
       case readServerConfigFileCmd.FullCommand(): err = doReadServerConfigFile()
@@ -230,6 +302,9 @@ func doICommand(line string, sess *session.Session) (err error) {
       case archivePublishCmd.FullCommand(): err = doPublishArchive(sess)
       case archiveGetCmd.FullCommand(): err = doGetArchive(sess)
       case archiveListCmd.FullCommand(): err = doListArchive(sess)
+      case archivePruneCmd.FullCommand(): err = doPruneArchive(sess)
+      case archiveVerifyCmd.FullCommand(): err = doVerifyArchive(sess)
+      case archiveDiffCmd.FullCommand(): err = doDiffArchive(sess)
       case watchEventsStartCmd.FullCommand(): err = doWatchEventsStart()
       case watchEventsStopCmd.FullCommand(): err = doWatchEventsStop()
     }
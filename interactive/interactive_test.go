@@ -7,4 +7,18 @@ import (
 
 func TestInteractiveTester(t *testing.T) {
   assert.True(t, true)
-}
\ No newline at end of file
+}
+
+func TestPublishNeedsServerAndType(t *testing.T) {
+  _, err := app.Parse([]string{"archive", "publish", "user", "server.zip", "--type", "MiscSnapshot"})
+  assert.Error(t, err)
+  _, err = app.Parse([]string{"archive", "publish", "user", "server.zip", "--server", "survival"})
+  assert.Error(t, err)
+  _, err = app.Parse([]string{"archive", "publish", "user", "server.zip", "--server", "survival", "--type", "Snapshot"})
+  assert.Error(t, err)
+  cmd, err := app.Parse([]string{"archive", "publish", "user", "server.zip", "--server", "survival", "--type", "WorldSnapshot"})
+  assert.NoError(t, err)
+  assert.Equal(t, archivePublishCmd.FullCommand(), cmd)
+  assert.Equal(t, "survival", serverNameArg)
+  assert.Equal(t, "WorldSnapshot", archiveTypeArg)
+}
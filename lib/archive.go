@@ -0,0 +1,98 @@
+package lib
+
+import(
+  "crypto/rand"
+  "encoding/hex"
+  "fmt"
+  "path"
+  "strings"
+  "time"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+// Archives are stored under: <user>/<server>/<ArchiveType>/<timestamp>-<random>.<ext>
+// The random part keeps snapshots taken in the same millisecond apart.
+// Incremental snapshots are stored as a manifest in the same place (see incremental.go),
+// encrypted archives have .age on the end (see encrypt.go).
+const archiveTimeFormat = "2006-01-02T15-04-05.000Z"
+
+var archiveExtensions = []string{FormatZip.Ext(), FormatTarGz.Ext(), FormatTarZst.Ext(), incrementalExt}
+
+// An archive in a store, with the pieces of its key broken out.
+type ArchiveEntry struct {
+  StoredObject
+  UserName string
+  ServerName string
+  Type mclib.ArchiveType
+  URI string
+}
+
+// The archive format, or incremental.
+func (e ArchiveEntry) Format() (string) {
+  if IsIncrementalKey(e.Key) { return "incremental" }
+  if f, ok := FormatFromKey(e.Key); ok { return string(f) }
+  return "unknown"
+}
+
+func (e ArchiveEntry) Encrypted() (bool) {
+  return IsEncryptedKey(e.Key)
+}
+
+type ByLastMod []ArchiveEntry
+func (a ByLastMod) Len() int { return len(a) }
+func (a ByLastMod) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByLastMod) Less(i, j int) bool { return a[i].LastMod.Before(a[j].LastMod) }
+
+// Key for a new archive.
+func ArchiveKey(user, server string, aType mclib.ArchiveType, t time.Time, ext string) (string) {
+  b := make([]byte, 4)
+  rand.Read(b)
+  return path.Join(user, server, aType.String(), t.UTC().Format(archiveTimeFormat) + "-" + hex.EncodeToString(b) + ext)
+}
+
+func isArchiveKey(key string) (bool) {
+  key = strings.TrimSuffix(key, encryptedExt)
+  for _, ext := range archiveExtensions {
+    if strings.HasSuffix(key, ext) { return true }
+  }
+  return false
+}
+
+// Break a key into an entry, ok is false if the key isn't laid out like an archive.
+func archiveEntryFromObject(store ArchiveStore, obj StoredObject) (entry ArchiveEntry, ok bool) {
+  parts := strings.Split(obj.Key, "/")
+  if len(parts) != 4 || !isArchiveKey(obj.Key) { return entry, false }
+  entry = ArchiveEntry{
+    StoredObject: obj,
+    UserName: parts[0],
+    ServerName: parts[1],
+    Type: mclib.ArchiveTypeFrom(parts[2]),
+    URI: store.URI(obj.Key),
+  }
+  return entry, true
+}
+
+// All of the archives for a user.
+func ListArchives(store ArchiveStore, user string) ([]ArchiveEntry, error) {
+  if user == "" { return nil, fmt.Errorf("Need a user to list archives.") }
+  objects, err := store.List(user + "/")
+  if err != nil { return nil, err }
+  entries := make([]ArchiveEntry, 0, len(objects))
+  for _, obj := range objects {
+    if e, ok := archiveEntryFromObject(store, obj); ok {
+      entries = append(entries, e)
+    }
+  }
+  return entries, nil
+}
+
+// Only the archives for one server.
+func ServerArchives(entries []ArchiveEntry, server string) ([]ArchiveEntry) {
+  al := make([]ArchiveEntry, 0)
+  for _, e := range entries {
+    if e.ServerName == server { al = append(al, e) }
+  }
+  return al
+}
@@ -0,0 +1,236 @@
+package lib
+
+import(
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+// Tab completion for the RCON prompt.
+//
+// Commands and their fixed arguments come from the server's help, e.g.
+// "/weather (clear|rain|thunder)" or the older "/time <set|add|query> <value>".
+// Arguments naming players (<targets>, <player> ...) complete from list,
+// which is asked again once the names are PlayerRefresh old.
+//
+// help is slow on servers that page it, so it's cached by server version.
+// Vanilla servers won't tell us their version over RCON, so theirs is
+// cached by address and asked again after helpCacheTTL.
+const(
+  DefaultPlayerRefresh = 30 * time.Second
+  helpCacheTTL = 24 * time.Hour
+  helpCacheExt = ".help"
+)
+
+var localCommands = []string{"quit", "exit"}
+
+// Usage arguments that take a player name.
+var playerArgs = map[string]bool{
+  "player": true,
+  "players": true,
+  "target": true,
+  "targets": true,
+  "targetPlayer": true,
+}
+
+var(
+  helpPagesRE = regexp.MustCompile(`Showing help page \d+ of (\d+)`)
+  versionRE = regexp.MustCompile(`running (.+?) \(MC: ([^)]+)\)`)
+)
+
+type completionNode struct {
+  words map[string]*completionNode
+  player *completionNode // Where a player name leads, nil if it can't go here.
+}
+
+func newCompletionNode() (*completionNode) {
+  return &completionNode{words: make(map[string]*completionNode)}
+}
+
+func (n *completionNode) word(w string) (*completionNode) {
+  next, ok := n.words[w]
+  if !ok {
+    next = newCompletionNode()
+    n.words[w] = next
+  }
+  return next
+}
+
+func (n *completionNode) playerArg() (*completionNode) {
+  if n.player == nil { n.player = newCompletionNode() }
+  return n.player
+}
+
+// Build the tree from help. Each command starts with a / that doesn't
+// follow an opening bracket or |, the way arguments do.
+func parseHelp(help string) (*completionNode) {
+  root := newCompletionNode()
+  help = formatRconResp(help)
+  start := -1
+  for i := 0; i <= len(help); i++ {
+    atCommand := i < len(help) && help[i] == '/' && i + 1 < len(help) && help[i+1] >= 'a' && help[i+1] <= 'z' &&
+      (i == 0 || !strings.ContainsRune("<[(|", rune(help[i-1])))
+    if i == len(help) || atCommand {
+      if start >= 0 { addUsage(root, help[start:i]) }
+      start = i + 1
+    }
+  }
+  return root
+}
+
+// Add one usage, e.g. "advancement (grant|revoke) <targets> everything",
+// going as far as the arguments we can complete.
+func addUsage(root *completionNode, usage string) {
+  tokens := strings.Fields(usage)
+  if len(tokens) == 0 { return }
+  nodes := []*completionNode{root.word(tokens[0])}
+  for _, tok := range tokens[1:] {
+    // Optional, [<duration>] now but [reason ...] or [player] before 1.13.
+    optional := tok[0] == '['
+    tok = strings.TrimSuffix(strings.TrimPrefix(tok, "["), "]")
+    if tok == "" { return }
+    inner := tok
+    if len(tok) > 2 && (tok[0] == '(' || tok[0] == '<') { inner = tok[1:len(tok)-1] }
+    named := tok[0] == '<' || (optional && inner == tok && !strings.Contains(tok, "|"))
+    var next []*completionNode
+    switch {
+    case named && playerArgs[inner]:
+      for _, n := range nodes { next = append(next, n.playerArg()) }
+    case strings.Contains(inner, "|") && !strings.ContainsAny(inner, "<>[]()"):
+      for _, n := range nodes {
+        for _, w := range strings.Split(inner, "|") { next = append(next, n.word(w)) }
+      }
+    case !named && inner == tok && !strings.ContainsAny(tok, "<>[]()|"):
+      for _, n := range nodes { next = append(next, n.word(tok)) }
+    default:
+      // Free text, a number, a position ...
+      return
+    }
+    nodes = next
+  }
+}
+
+// Completes commands for an RCON connection, readline.AutoCompleter.
+type RconCompleter struct {
+  PlayerRefresh time.Duration
+
+  rcon RconSender
+  root *completionNode
+  mu sync.Mutex
+  players []string
+  playersAt time.Time
+}
+
+// help is the server's help output, see LoadRconHelp.
+func NewRconCompleter(rcon RconSender, help string) (*RconCompleter) {
+  root := parseHelp(help)
+  for _, c := range localCommands { root.word(c) }
+  // It would only be refused.
+  delete(root.words, "stop")
+  return &RconCompleter{PlayerRefresh: DefaultPlayerRefresh, rcon: rcon, root: root}
+}
+
+func (c *RconCompleter) playerNames() ([]string) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if time.Since(c.playersAt) < c.PlayerRefresh { return c.players }
+  c.playersAt = time.Now()
+  resp, err := c.rcon.Send("list")
+  if err != nil { return c.players }
+  pl, err := ParseList(formatRconResp(resp))
+  if err != nil { return c.players }
+  c.players = make([]string, len(pl.Players))
+  for i, p := range pl.Players { c.players[i] = p.Name }
+  return c.players
+}
+
+func (c *RconCompleter) Do(line []rune, pos int) (newLine [][]rune, length int) {
+  text := string(line[:pos])
+  words := strings.Fields(text)
+  partial := ""
+  if len(words) > 0 && !strings.HasSuffix(text, " ") {
+    partial = words[len(words)-1]
+    words = words[:len(words)-1]
+  }
+  if len(words) == 0 { partial = strings.TrimPrefix(partial, "/") }
+
+  node := c.root
+  for i, w := range words {
+    if i == 0 { w = strings.TrimPrefix(w, "/") }
+    if next, ok := node.words[w]; ok {
+      node = next
+    } else if node.player != nil {
+      node = node.player
+    } else {
+      return nil, 0
+    }
+  }
+
+  candidates := make([]string, 0, len(node.words))
+  for w := range node.words { candidates = append(candidates, w) }
+  if node.player != nil { candidates = append(candidates, c.playerNames()...) }
+  sort.Strings(candidates)
+  for _, cand := range candidates {
+    if strings.HasPrefix(cand, partial) {
+      newLine = append(newLine, []rune(cand[len(partial):] + " "))
+    }
+  }
+  return newLine, len([]rune(partial))
+}
+
+// Where help is cached unless told otherwise.
+func DefaultHelpCacheDir() (string) {
+  dir, err := os.UserCacheDir()
+  if err != nil { dir = os.TempDir() }
+  return filepath.Join(dir, "craft-config", "rcon-help")
+}
+
+// Bukkit, Spigot and Paper say what they're running, e.g.
+// "This server is running Paper version git-Paper-196 (MC: 1.20.1) ...".
+// Empty for vanilla.
+func serverVersion(rcon RconSender) (string) {
+  resp, err := rcon.Send("version")
+  if err != nil { return "" }
+  m := versionRE.FindStringSubmatch(formatRconResp(resp))
+  if m == nil { return "" }
+  return m[1] + "-" + m[2]
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// The server's help, all pages of it, from the cache in cacheDir if we can.
+// addr identifies servers that won't give their version.
+func LoadRconHelp(rcon RconSender, cacheDir, addr string) (string, error) {
+  version := serverVersion(rcon)
+  key := "version-" + version
+  if version == "" { key = "server-" + addr }
+  cacheFile := filepath.Join(cacheDir, unsafeFileChars.ReplaceAllString(key, "_") + helpCacheExt)
+
+  if info, err := os.Stat(cacheFile); err == nil && (version != "" || time.Since(info.ModTime()) < helpCacheTTL) {
+    if b, err := ioutil.ReadFile(cacheFile); err == nil { return string(b), nil }
+  }
+
+  help, err := rcon.Send("help")
+  if err != nil { return "", err }
+  if m := helpPagesRE.FindStringSubmatch(formatRconResp(help)); m != nil {
+    pages, _ := strconv.Atoi(m[1])
+    for p := 2; p <= pages; p++ {
+      page, err := rcon.Send(fmt.Sprintf("help %d", p))
+      if err != nil { return "", err }
+      help += page
+    }
+  }
+
+  // The cache is only a convenience.
+  if err = os.MkdirAll(cacheDir, 0700); err == nil {
+    ioutil.WriteFile(cacheFile, []byte(help), 0600)
+  }
+  return help, nil
+}
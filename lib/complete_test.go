@@ -0,0 +1,60 @@
+package lib
+
+import (
+  "context"
+  "io/ioutil"
+  "os"
+  "testing"
+  "craft-config/lib/rcontest"
+  "github.com/stretchr/testify/assert"
+)
+
+func complete(c *RconCompleter, line string) (candidates []string) {
+  newLine, _ := c.Do([]rune(line), len([]rune(line)))
+  for _, l := range newLine { candidates = append(candidates, string(l)) }
+  return candidates
+}
+
+func TestRconCompleter(t *testing.T) {
+  srv, err := rcontest.NewServer("secret")
+  if !assert.NoError(t, err) { return }
+  defer srv.Close()
+  // 1.12 pages its help.
+  srv.Respond("help", "--- Showing help page 1 of 2 (/help <page>) ---/kick <player> [reason ...]/stop/time <set|add|query> <value>")
+  srv.Respond("help 2", "--- Showing help page 2 of 2 (/help <page>) ---/weather (clear|rain|thunder) [<duration>]/whitelist (add|remove) <player>")
+  srv.SetUsers("alice", "bob")
+  cacheDir, err := ioutil.TempDir("", "rcon-help")
+  assert.NoError(t, err)
+  defer os.RemoveAll(cacheDir)
+
+  c, err := DialRcon(context.Background(), srv.Addr(), "secret")
+  if !assert.NoError(t, err) { return }
+  defer c.Close()
+  help, err := LoadRconHelp(c, cacheDir, srv.Addr())
+  assert.NoError(t, err)
+  comp := NewRconCompleter(c, help)
+
+  // Just what's left of each word.
+  assert.Equal(t, []string{"eather ", "hitelist "}, complete(comp, "w"))
+  assert.Equal(t, []string{"xit "}, complete(comp, "/e"))
+  assert.Nil(t, complete(comp, "sto"))
+  assert.Equal(t, []string{"add ", "query ", "set "}, complete(comp, "time "))
+  assert.Equal(t, []string{"lice "}, complete(comp, "whitelist add a"))
+  assert.Equal(t, []string{"alice ", "bob "}, complete(comp, "kick "))
+  assert.Nil(t, complete(comp, "kick alice "))
+
+  // Names are only asked for again once they're stale.
+  srv.SetUsers("carol")
+  assert.Equal(t, []string{"alice ", "bob "}, complete(comp, "kick "))
+  comp.PlayerRefresh = 0
+  assert.Equal(t, []string{"carol "}, complete(comp, "kick "))
+
+  // The second time help comes from the cache.
+  _, err = LoadRconHelp(c, cacheDir, srv.Addr())
+  assert.NoError(t, err)
+  helps := 0
+  for _, cmd := range srv.Commands() {
+    if cmd == "help" { helps++ }
+  }
+  assert.Equal(t, 1, helps)
+}
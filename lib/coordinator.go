@@ -0,0 +1,187 @@
+package lib
+
+import(
+  "context"
+  "fmt"
+  "strings"
+  "sync"
+  "time"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+// Why a snapshot was asked for.
+const(
+  ReasonTimer = "timer"
+  ReasonSchedule = "schedule"
+  ReasonUserChange = "userChange"
+  ReasonSessionEnd = "sessionEnd"
+  ReasonManual = "manual"
+  ReasonStop = "stop"
+)
+
+// Takes one snapshot, giving up when ctx is done.
+type SnapshotFunc func(ctx context.Context, aType mclib.ArchiveType) (error)
+
+// Runs a server's snapshots one at a time on its own goroutine.
+// Requests for a type that's already waiting are merged into the waiting
+// one, so ticks that pile up behind a slow snapshot cost one snapshot,
+// not one each. Each snapshot gets Timeout to finish before it's cancelled,
+// zero for no limit.
+type SnapshotCoordinator struct {
+  Timeout time.Duration
+  // Called after each batch of snapshots, e.g. to prune.
+  AfterBatch func()
+
+  server *mclib.Server
+  snapshot SnapshotFunc
+
+  mu sync.Mutex
+  pending map[mclib.ArchiveType][]string // Reasons.
+  closing bool
+  wake chan struct{}
+  done chan error
+  cancel context.CancelFunc
+}
+
+// Worlds go before servers, as they always have.
+var snapshotOrder = []mclib.ArchiveType{mclib.WorldSnapshot, mclib.ServerSnapshot, mclib.MiscSnapshot}
+
+func NewSnapshotCoordinator(s *mclib.Server, fn SnapshotFunc, timeout time.Duration) (*SnapshotCoordinator) {
+  return &SnapshotCoordinator{
+    Timeout: timeout,
+    server: s,
+    snapshot: fn,
+    pending: make(map[mclib.ArchiveType][]string),
+    wake: make(chan struct{}, 1),
+    done: make(chan error, 1),
+  }
+}
+
+// Start the worker.
+func (c *SnapshotCoordinator) Start() {
+  ctx, cancel := context.WithCancel(context.Background())
+  c.cancel = cancel
+  go func() {
+    c.run(ctx)
+    cancel()
+  }()
+}
+
+// Ask for snapshots, without waiting for them. Returns false if the
+// coordinator is closed.
+func (c *SnapshotCoordinator) Request(reason string, types ...mclib.ArchiveType) (bool) {
+  f := c.server.LogFields()
+  f["operation"] = "SnapshotRequest"
+  f["reason"] = reason
+
+  c.mu.Lock()
+  if c.closing {
+    c.mu.Unlock()
+    log.Debug(f, "Snapshot coordinator is closed, dropping request.")
+    return false
+  }
+  merged := make([]string, 0)
+  for _, t := range types {
+    if _, waiting := c.pending[t]; waiting { merged = append(merged, t.String()) }
+    c.pending[t] = append(c.pending[t], reason)
+  }
+  c.mu.Unlock()
+
+  if len(merged) > 0 {
+    f["merged"] = strings.Join(merged, ",")
+    log.Debug(f, "Merged snapshot request with one already waiting.")
+  }
+  select {
+  case c.wake <- struct{}{}:
+  default:
+  }
+  return true
+}
+
+// Stop taking requests, finish what's waiting and report the first
+// failure of those on the returned channel. Abort cancels what's left.
+func (c *SnapshotCoordinator) Close() (<-chan error) {
+  c.mu.Lock()
+  c.closing = true
+  c.mu.Unlock()
+  select {
+  case c.wake <- struct{}{}:
+  default:
+  }
+  return c.done
+}
+
+// Cancel any running snapshot and drop the rest.
+func (c *SnapshotCoordinator) Abort() {
+  c.mu.Lock()
+  c.closing = true
+  c.pending = make(map[mclib.ArchiveType][]string)
+  c.mu.Unlock()
+  if c.cancel != nil { c.cancel() }
+}
+
+// Take the waiting requests, in order.
+func (c *SnapshotCoordinator) takePending() (types []mclib.ArchiveType, reasons map[mclib.ArchiveType][]string, closing bool) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  for _, t := range snapshotOrder {
+    if _, ok := c.pending[t]; ok { types = append(types, t) }
+  }
+  reasons = c.pending
+  c.pending = make(map[mclib.ArchiveType][]string)
+  return types, reasons, c.closing
+}
+
+func (c *SnapshotCoordinator) run(ctx context.Context) {
+  var failed error
+  for {
+    select {
+    case <- c.wake:
+    case <- ctx.Done():
+    }
+    // Nothing can be added once we're closing, so this is the last batch.
+    types, reasons, closing := c.takePending()
+    for _, t := range types {
+      if ctx.Err() != nil { break }
+      err := c.runOne(ctx, t, reasons[t])
+      if closing && err != nil && failed == nil { failed = err }
+    }
+    if len(types) > 0 && c.AfterBatch != nil && ctx.Err() == nil { c.AfterBatch() }
+    if closing || ctx.Err() != nil {
+      if failed == nil { failed = ctx.Err() }
+      c.done <- failed
+      return
+    }
+  }
+}
+
+func (c *SnapshotCoordinator) runOne(ctx context.Context, t mclib.ArchiveType, reasons []string) (error) {
+  f := c.server.LogFields()
+  f["operation"] = "Snapshot"
+  f["snapshotType"] = t.String()
+  f["reasons"] = strings.Join(reasons, ",")
+  f["timeout"] = c.Timeout.String()
+
+  var sctx context.Context
+  var cancel context.CancelFunc
+  if c.Timeout > 0 {
+    sctx, cancel = context.WithTimeout(ctx, c.Timeout)
+  } else {
+    sctx, cancel = context.WithCancel(ctx)
+  }
+  defer cancel()
+  start := time.Now()
+  err := c.snapshot(sctx, t)
+  if err != nil && sctx.Err() == context.DeadlineExceeded {
+    err = fmt.Errorf("Snapshot timed out after %s: %s", c.Timeout, err)
+  }
+  f["elapsed"] = time.Since(start).String()
+  if err != nil {
+    log.Debug(f, "Coordinated snapshot failed.")
+  } else {
+    log.Debug(f, "Coordinated snapshot finished.")
+  }
+  return err
+}
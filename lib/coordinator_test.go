@@ -0,0 +1,77 @@
+package lib
+
+import (
+  "context"
+  "fmt"
+  "sync"
+  "testing"
+  "time"
+  "github.com/stretchr/testify/assert"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+func TestSnapshotCoordinator(t *testing.T) {
+  var mu sync.Mutex
+  running, maxRunning := 0, 0
+  taken := make([]mclib.ArchiveType, 0)
+  release := make(chan struct{})
+
+  s := &mclib.Server{User: "testuser", Name: "testserver"}
+  c := NewSnapshotCoordinator(s, func(ctx context.Context, aType mclib.ArchiveType) (error) {
+    mu.Lock()
+    running++
+    if running > maxRunning { maxRunning = running }
+    taken = append(taken, aType)
+    mu.Unlock()
+    <-release
+    mu.Lock()
+    running--
+    mu.Unlock()
+    return nil
+  }, time.Minute)
+  batches := 0
+  c.AfterBatch = func() { batches++ }
+  c.Start()
+
+  // The first request starts straight away, the rest pile up behind it.
+  c.Request(ReasonTimer, mclib.WorldSnapshot)
+  assert.Eventually(t, func() bool { mu.Lock(); defer mu.Unlock(); return running == 1 }, time.Second, time.Millisecond)
+  for i := 0; i < 5; i++ {
+    c.Request(ReasonUserChange, mclib.ServerSnapshot, mclib.WorldSnapshot)
+  }
+  close(release)
+
+  done := c.Close()
+  select {
+  case err := <-done:
+    assert.NoError(t, err)
+  case <-time.After(5 * time.Second):
+    t.Fatal("Coordinator didn't finish.")
+  }
+  assert.False(t, c.Request(ReasonManual, mclib.WorldSnapshot))
+
+  // The five piled up requests became one of each, worlds first.
+  assert.Equal(t, []mclib.ArchiveType{mclib.WorldSnapshot, mclib.WorldSnapshot, mclib.ServerSnapshot}, taken)
+  assert.Equal(t, 1, maxRunning)
+  assert.Equal(t, 2, batches)
+}
+
+func TestSnapshotCoordinatorTimeout(t *testing.T) {
+  s := &mclib.Server{User: "testuser", Name: "testserver"}
+  c := NewSnapshotCoordinator(s, func(ctx context.Context, aType mclib.ArchiveType) (error) {
+    <-ctx.Done()
+    return fmt.Errorf("cancelled: %s", ctx.Err())
+  }, 10 * time.Millisecond)
+  c.Start()
+  c.Request(ReasonStop, mclib.ServerSnapshot)
+  select {
+  case err := <-c.Close():
+    if assert.Error(t, err) {
+      assert.Contains(t, err.Error(), "timed out")
+    }
+  case <-time.After(5 * time.Second):
+    t.Fatal("Snapshot wasn't cancelled.")
+  }
+}
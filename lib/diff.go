@@ -0,0 +1,256 @@
+package lib
+
+import(
+  "crypto/sha256"
+  "encoding/binary"
+  "encoding/hex"
+  "fmt"
+  "io"
+  "os"
+  "path"
+  "sort"
+  "strings"
+  "github.com/aws/aws-sdk-go/aws/session"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+// Compare two snapshots, or a snapshot and a live server directory.
+// Anything that isn't an s3:// or file:// URI is taken to be a server directory.
+//
+// Region (.mca) files start with two 4KiB tables of 1024 entries each:
+// chunk locations, then the big-endian int32 time each chunk was last saved.
+// We count the chunks whose timestamps differ.
+const(
+  regionExt = ".mca"
+  regionChunks = 1024
+  regionHeaderSize = 8192
+  regionTimestampsOffset = 4096
+)
+
+const(
+  FileAdded = "Added"
+  FileRemoved = "Removed"
+  FileModified = "Modified"
+)
+
+type FileDiff struct {
+  Path string
+  Change string
+  OldSize int64
+  NewSize int64
+  IsRegion bool
+  // Modified regions: chunks with a new timestamp. Added or removed regions: chunks in the file.
+  ChangedChunks int
+}
+
+type byPath []FileDiff
+func (a byPath) Len() int { return len(a) }
+func (a byPath) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byPath) Less(i, j int) bool { return a[i].Path < a[j].Path }
+
+type ArchiveDiff struct {
+  A string
+  B string
+  Files []FileDiff
+}
+
+func (d *ArchiveDiff) Count(change string) (n int) {
+  for _, fd := range d.Files {
+    if fd.Change == change { n++ }
+  }
+  return n
+}
+
+type diffFile struct {
+  size int64
+  sha256 string
+  timestamps []int32 // Region files only.
+}
+
+type diffSource struct {
+  name string
+  dir string // Live server directory, empty for an archive.
+  store ArchiveStore
+  key string
+}
+
+func isRegionFile(name string) (bool) {
+  return strings.HasSuffix(name, regionExt)
+}
+
+func newDiffSource(name string, sess *session.Session) (*diffSource, error) {
+  if !strings.Contains(name, "://") {
+    info, err := os.Stat(name)
+    if err != nil { return nil, err }
+    if !info.IsDir() { return nil, fmt.Errorf("%s is not a server directory or an archive URI.", name) }
+    return &diffSource{name: name, dir: name}, nil
+  }
+  store, key, err := OpenArchiveURI(name, sess)
+  if err != nil { return nil, err }
+  return &diffSource{name: name, store: store, key: key}, nil
+}
+
+// The type of archive from its key, ok is false for a live directory or an odd key.
+func (ds *diffSource) archiveType() (aType mclib.ArchiveType, ok bool) {
+  if ds.dir != "" { return aType, false }
+  t := path.Base(path.Dir(ds.key))
+  for _, at := range []mclib.ArchiveType{mclib.ServerSnapshot, mclib.WorldSnapshot, mclib.MiscSnapshot} {
+    if t == at.String() { return at, true }
+  }
+  return aType, false
+}
+
+// Describe every file, like is only used for a live directory: it's
+// limited to what would be in a snapshot of the same type.
+func (ds *diffSource) files(like *diffSource, enc *Encryption) (map[string]diffFile, error) {
+  if ds.dir != "" { return dirDiffFiles(ds.dir, like) }
+  if IsIncrementalKey(ds.key) { return incrementalDiffFiles(ds.store, ds.key) }
+  rc, err := ds.store.Get(ds.key)
+  if err != nil { return nil, err }
+  defer rc.Close()
+  r, err := decryptIfNeeded(rc, enc)
+  if err != nil { return nil, err }
+  format, _ := FormatFromKey(ds.key)
+  files := make(map[string]diffFile)
+  err = walkArchive(format, r, func(e archiveEntry, r io.Reader) (error) {
+    if e.IsDir { return nil }
+    df, err := describeFile(e.Name, r)
+    if err != nil { return fmt.Errorf("%s: %s", e.Name, err) }
+    files[e.Name] = df
+    return nil
+  })
+  return files, err
+}
+
+func dirDiffFiles(dir string, like *diffSource) (map[string]diffFile, error) {
+  roots := []string{"."}
+  if aType, ok := like.archiveType(); ok && aType != mclib.MiscSnapshot {
+    var err error
+    if roots, err = SnapshotFiles(dir, aType, nil); err != nil { return nil, err }
+  }
+  rules, err := LoadIgnoreRules(dir, nil)
+  if err != nil { return nil, err }
+  files := make(map[string]diffFile)
+  err = WalkFiles(dir, roots, rules, func(p, rel string, info os.FileInfo) (error) {
+    if info.IsDir() { return nil }
+    file, err := os.Open(p)
+    if err != nil { return err }
+    defer file.Close()
+    df, err := describeFile(rel, file)
+    if err != nil { return fmt.Errorf("%s: %s", rel, err) }
+    files[rel] = df
+    return nil
+  })
+  return files, err
+}
+
+// The manifest has the checksums, we only need to fetch region headers.
+func incrementalDiffFiles(store ArchiveStore, key string) (map[string]diffFile, error) {
+  m, err := GetManifest(store, key, nil)
+  if err != nil { return nil, err }
+  prefix := blobPrefix(key)
+  files := make(map[string]diffFile, len(m.Files))
+  for _, mf := range m.Files {
+    df := diffFile{size: mf.Size, sha256: mf.SHA256}
+    if isRegionFile(mf.Path) {
+      r, err := store.Get(blobKey(prefix, mf.SHA256))
+      if err != nil { return nil, fmt.Errorf("%s: missing blob %s", mf.Path, mf.SHA256) }
+      head, err := readRegionHeader(r)
+      r.Close()
+      if err != nil { return nil, fmt.Errorf("%s: %s", mf.Path, err) }
+      df.timestamps = regionTimestamps(head)
+    }
+    files[mf.Path] = df
+  }
+  return files, nil
+}
+
+func readRegionHeader(r io.Reader) ([]byte, error) {
+  head := make([]byte, regionHeaderSize)
+  n, err := io.ReadFull(r, head)
+  if err == io.EOF || err == io.ErrUnexpectedEOF { err = nil }
+  return head[:n], err
+}
+
+// Size and checksum of the contents, and chunk timestamps for region files.
+func describeFile(name string, r io.Reader) (df diffFile, err error) {
+  h := sha256.New()
+  if isRegionFile(name) {
+    head, err := readRegionHeader(r)
+    if err != nil { return df, err }
+    h.Write(head)
+    df.size = int64(len(head))
+    df.timestamps = regionTimestamps(head)
+  }
+  n, err := io.Copy(h, r)
+  if err != nil { return df, err }
+  df.size += n
+  df.sha256 = hex.EncodeToString(h.Sum(nil))
+  return df, nil
+}
+
+// nil if the header is incomplete, e.g. an empty region file.
+func regionTimestamps(head []byte) ([]int32) {
+  if len(head) < regionHeaderSize { return nil }
+  ts := make([]int32, regionChunks)
+  for i := range ts {
+    off := regionTimestampsOffset + i * 4
+    ts[i] = int32(binary.BigEndian.Uint32(head[off:off+4]))
+  }
+  return ts
+}
+
+func changedChunks(a, b []int32) (n int) {
+  for i := 0; i < regionChunks; i++ {
+    var ta, tb int32
+    if a != nil { ta = a[i] }
+    if b != nil { tb = b[i] }
+    if ta != tb { n++ }
+  }
+  return n
+}
+
+// Report the files added, removed and modified going from a to b.
+// Encrypted archives are decrypted with enc.
+func DiffArchives(a, b string, sess *session.Session, enc *Encryption) (*ArchiveDiff, error) {
+  sa, err := newDiffSource(a, sess)
+  if err != nil { return nil, err }
+  sb, err := newDiffSource(b, sess)
+  if err != nil { return nil, err }
+  fa, err := sa.files(sb, enc)
+  if err != nil { return nil, fmt.Errorf("Couldn't read %s: %s", a, err) }
+  fb, err := sb.files(sa, enc)
+  if err != nil { return nil, fmt.Errorf("Couldn't read %s: %s", b, err) }
+
+  diff := &ArchiveDiff{A: a, B: b, Files: make([]FileDiff, 0)}
+  for p, dfa := range fa {
+    fd := FileDiff{Path: p, OldSize: dfa.size, IsRegion: isRegionFile(p)}
+    dfb, ok := fb[p]
+    switch {
+    case !ok:
+      fd.Change = FileRemoved
+      fd.ChangedChunks = changedChunks(dfa.timestamps, nil)
+    case dfa.sha256 != dfb.sha256:
+      fd.Change = FileModified
+      fd.NewSize = dfb.size
+      fd.ChangedChunks = changedChunks(dfa.timestamps, dfb.timestamps)
+    default:
+      continue
+    }
+    diff.Files = append(diff.Files, fd)
+  }
+  for p, dfb := range fb {
+    if _, ok := fa[p]; ok { continue }
+    diff.Files = append(diff.Files, FileDiff{
+      Path: p,
+      Change: FileAdded,
+      NewSize: dfb.size,
+      IsRegion: isRegionFile(p),
+      ChangedChunks: changedChunks(nil, dfb.timestamps),
+    })
+  }
+  sort.Sort(byPath(diff.Files))
+  return diff, nil
+}
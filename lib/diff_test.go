@@ -0,0 +1,68 @@
+package lib
+
+import (
+  "encoding/binary"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  "github.com/stretchr/testify/assert"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+// A region file with the given chunk timestamps and no chunk data.
+func regionFile(timestamps map[int]int32) ([]byte) {
+  b := make([]byte, regionHeaderSize)
+  for i, ts := range timestamps {
+    binary.BigEndian.PutUint32(b[regionTimestampsOffset + i * 4:], uint32(ts))
+  }
+  return b
+}
+
+func TestDiffArchives(t *testing.T) {
+  dir, err := ioutil.TempDir("", "diff-test")
+  assert.NoError(t, err)
+  defer os.RemoveAll(dir)
+
+  serverDir := filepath.Join(dir, "server")
+  world := filepath.Join(serverDir, "world")
+  assert.NoError(t, os.MkdirAll(filepath.Join(world, "region"), 0755))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(world, "level.dat"), []byte("level"), 0644))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(world, "gone.dat"), []byte("gone"), 0644))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(world, "region", "r.0.0.mca"), regionFile(map[int]int32{0: 100, 1: 100, 2: 100}), 0644))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "server.properties"), []byte("level-name=world\n"), 0644))
+
+  store := NewFileStore(filepath.Join(dir, "archives"))
+  s := &mclib.Server{User: "testuser", Name: "testserver", ServerDirectory: serverDir}
+  before, err := TakeSnapshot(s, mclib.WorldSnapshot, store, SnapshotOptions{})
+  if !assert.NoError(t, err) { return }
+
+  assert.NoError(t, os.Remove(filepath.Join(world, "gone.dat")))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(world, "new.dat"), []byte("new"), 0644))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(world, "region", "r.0.0.mca"), regionFile(map[int]int32{0: 100, 1: 200, 3: 200}), 0644))
+  // Not in a world snapshot, so not compared against the live directory.
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "ops.json"), []byte("[]"), 0644))
+
+  after, err := TakeSnapshot(s, mclib.WorldSnapshot, store, SnapshotOptions{Incremental: true})
+  if !assert.NoError(t, err) { return }
+
+  for _, b := range []string{after.URI, serverDir} {
+    diff, err := DiffArchives(before.URI, b, nil, nil)
+    if !assert.NoError(t, err, b) { continue }
+    if assert.Equal(t, 3, len(diff.Files), b) {
+      assert.Equal(t, FileDiff{Path: "world/gone.dat", Change: FileRemoved, OldSize: 4}, diff.Files[0])
+      assert.Equal(t, FileDiff{Path: "world/new.dat", Change: FileAdded, NewSize: 3}, diff.Files[1])
+      region := diff.Files[2]
+      assert.Equal(t, FileModified, region.Change)
+      assert.True(t, region.IsRegion)
+      // Chunk 1 was saved again, 2 was removed and 3 is new.
+      assert.Equal(t, 3, region.ChangedChunks)
+    }
+  }
+
+  diff, err := DiffArchives(serverDir, serverDir, nil, nil)
+  assert.NoError(t, err)
+  assert.Equal(t, 0, len(diff.Files))
+}
@@ -0,0 +1,125 @@
+package lib
+
+import(
+  "bufio"
+  "bytes"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "strings"
+  "filippo.io/age"
+)
+
+// Archives can be encrypted with age (https://age-encryption.org) before
+// they leave the host, either to one or more public keys or with a passphrase.
+// Encrypted archives get an extra extension: <timestamp>-<random>.zip.age, and
+// their manifests are encrypted with them.
+const encryptedExt = ".age"
+
+var ageHeader = []byte("age-encryption.org/v1\n")
+
+type Encryption struct {
+  recipients []age.Recipient
+  identities []age.Identity
+}
+
+// Keys come from:
+//   recipients      age public keys (age1...) to encrypt to.
+//   keyFile         an age identity file, as made by age-keygen. Used to decrypt,
+//                   and its public keys are also encrypted to.
+//   passphraseFile  a file whose first line is a passphrase, used for both.
+// Returns nil if none of them are given.
+func NewEncryption(recipients []string, keyFile, passphraseFile string) (enc *Encryption, err error) {
+  if len(recipients) == 0 && keyFile == "" && passphraseFile == "" { return nil, nil }
+  enc = &Encryption{}
+
+  for _, rs := range recipients {
+    r, err := age.ParseX25519Recipient(strings.TrimSpace(rs))
+    if err != nil { return nil, fmt.Errorf("Bad recipient \"%s\": %s", rs, err) }
+    enc.recipients = append(enc.recipients, r)
+  }
+
+  if keyFile != "" {
+    file, err := os.Open(keyFile)
+    if err != nil { return nil, err }
+    defer file.Close()
+    ids, err := age.ParseIdentities(file)
+    if err != nil { return nil, fmt.Errorf("Couldn't read key file %s: %s", keyFile, err) }
+    for _, id := range ids {
+      enc.identities = append(enc.identities, id)
+      if x, ok := id.(*age.X25519Identity); ok {
+        enc.recipients = append(enc.recipients, x.Recipient())
+      }
+    }
+  }
+
+  if passphraseFile != "" {
+    if len(enc.recipients) > 0 {
+      return nil, fmt.Errorf("A passphrase can't be combined with recipients or a key file.")
+    }
+    b, err := ioutil.ReadFile(passphraseFile)
+    if err != nil { return nil, err }
+    pass := strings.TrimRight(strings.SplitN(string(b), "\n", 2)[0], "\r")
+    if pass == "" { return nil, fmt.Errorf("Passphrase file %s is empty.", passphraseFile) }
+    r, err := age.NewScryptRecipient(pass)
+    if err != nil { return nil, err }
+    id, err := age.NewScryptIdentity(pass)
+    if err != nil { return nil, err }
+    enc.recipients = append(enc.recipients, r)
+    enc.identities = append(enc.identities, id)
+  }
+  return enc, nil
+}
+
+// Will we encrypt new archives?
+func (e *Encryption) Encrypts() (bool) {
+  return e != nil && len(e.recipients) > 0
+}
+
+// Writes to the returned writer are encrypted to w, it must be closed
+// to finish the encryption.
+func (e *Encryption) Encrypt(w io.Writer) (io.WriteCloser, error) {
+  if !e.Encrypts() { return nil, fmt.Errorf("No recipients or passphrase to encrypt with.") }
+  return age.Encrypt(w, e.recipients...)
+}
+
+func (e *Encryption) Decrypt(r io.Reader) (io.Reader, error) {
+  if e == nil || len(e.identities) == 0 {
+    return nil, fmt.Errorf("Archive is encrypted, a key file or passphrase is needed to read it.")
+  }
+  return age.Decrypt(r, e.identities...)
+}
+
+func IsEncryptedKey(key string) (bool) {
+  return strings.HasSuffix(key, encryptedExt)
+}
+
+// Decrypt r if it's encrypted, otherwise hand back its contents untouched.
+func decryptIfNeeded(r io.Reader, e *Encryption) (io.Reader, error) {
+  br := bufio.NewReader(r)
+  header, _ := br.Peek(len(ageHeader))
+  if !bytes.Equal(header, ageHeader) { return br, nil }
+  return e.Decrypt(br)
+}
+
+// Put r in the store, encrypting it on the way if enc encrypts,
+// in which case .age is added to the key. Returns the key used.
+func PutEncrypted(store ArchiveStore, key string, r io.Reader, enc *Encryption) (obj *StoredObject, storedKey string, err error) {
+  if !enc.Encrypts() {
+    obj, err = store.Put(key, r)
+    return obj, key, err
+  }
+  key += encryptedExt
+  pr, pw := io.Pipe()
+  go func() {
+    w, err := enc.Encrypt(pw)
+    if err == nil {
+      if _, err = io.Copy(w, r); err == nil { err = w.Close() }
+    }
+    pw.CloseWithError(err)
+  }()
+  obj, err = store.Put(key, pr)
+  pr.Close()
+  return obj, key, err
+}
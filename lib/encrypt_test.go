@@ -0,0 +1,91 @@
+package lib
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "filippo.io/age"
+  "github.com/stretchr/testify/assert"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+func TestEncryptedSnapshot(t *testing.T) {
+  dir, err := ioutil.TempDir("", "encrypt-test")
+  assert.NoError(t, err)
+  defer os.RemoveAll(dir)
+
+  id, err := age.GenerateX25519Identity()
+  assert.NoError(t, err)
+  keyFile := filepath.Join(dir, "key.txt")
+  assert.NoError(t, ioutil.WriteFile(keyFile, []byte(id.String() + "\n"), 0600))
+  enc, err := NewEncryption(nil, keyFile, "")
+  assert.NoError(t, err)
+  assert.True(t, enc.Encrypts())
+
+  serverDir := filepath.Join(dir, "server")
+  assert.NoError(t, os.MkdirAll(filepath.Join(serverDir, "world", "playerdata"), 0755))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "world", "playerdata", "player.dat"), []byte("secret"), 0644))
+
+  store := NewFileStore(filepath.Join(dir, "archives"))
+  s := &mclib.Server{User: "testuser", Name: "testserver", ServerDirectory: serverDir}
+  result, err := TakeSnapshot(s, mclib.WorldSnapshot, store, SnapshotOptions{Format: FormatTarGz, Encryption: enc})
+  if !assert.NoError(t, err) { return }
+  assert.True(t, strings.HasSuffix(result.Object.Key, ".tar.gz.age"))
+
+  al, err := ListArchives(store, "testuser")
+  assert.NoError(t, err)
+  if assert.Equal(t, 1, len(al)) {
+    assert.True(t, al[0].Encrypted())
+    assert.Equal(t, "tar.gz", al[0].Format())
+  }
+
+  b, err := ioutil.ReadFile(filepath.Join(store.Root, filepath.FromSlash(ManifestKey(result.Object.Key))))
+  assert.NoError(t, err)
+  assert.False(t, strings.Contains(string(b), "playerdata"))
+
+  // Can't be read without the key.
+  _, err = VerifyArchive(result.URI, nil, nil)
+  assert.Error(t, err)
+
+  report, err := VerifyArchive(result.URI, nil, enc)
+  if assert.NoError(t, err) {
+    assert.True(t, report.OK(), "%v", report.Problems)
+    assert.Equal(t, 1, report.Checked)
+  }
+
+  restored, err := RestoreArchive(result.URI, "127.0.0.1", mclib.Port(1), serverDir, nil, enc)
+  if assert.NoError(t, err) {
+    assert.Equal(t, 1, restored.Files)
+    b, _ = ioutil.ReadFile(filepath.Join(serverDir, "world", "playerdata", "player.dat"))
+    assert.Equal(t, "secret", string(b))
+  }
+
+  // Encrypted incrementals aren't supported.
+  _, err = TakeSnapshot(s, mclib.WorldSnapshot, store, SnapshotOptions{Incremental: true, Encryption: enc})
+  assert.Error(t, err)
+}
+
+func TestNewEncryption(t *testing.T) {
+  enc, err := NewEncryption(nil, "", "")
+  assert.NoError(t, err)
+  assert.False(t, enc.Encrypts())
+
+  _, err = NewEncryption([]string{"not-a-key"}, "", "")
+  assert.Error(t, err)
+
+  id, _ := age.GenerateX25519Identity()
+  dir, err := ioutil.TempDir("", "encrypt-test")
+  assert.NoError(t, err)
+  defer os.RemoveAll(dir)
+  passFile := filepath.Join(dir, "pass")
+  assert.NoError(t, ioutil.WriteFile(passFile, []byte("correct horse\n"), 0600))
+  _, err = NewEncryption([]string{id.Recipient().String()}, "", passFile)
+  assert.Error(t, err)
+  enc, err = NewEncryption(nil, "", passFile)
+  assert.NoError(t, err)
+  assert.True(t, enc.Encrypts())
+}
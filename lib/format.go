@@ -0,0 +1,315 @@
+package lib
+
+import(
+  "archive/tar"
+  "archive/zip"
+  "bufio"
+  "bytes"
+  "compress/gzip"
+  "crypto/sha256"
+  "encoding/hex"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
+  "github.com/klauspost/compress/zstd"
+)
+
+// Archive file formats.
+type ArchiveFormat string
+
+const(
+  FormatZip ArchiveFormat = "zip"
+  FormatTarGz ArchiveFormat = "tar.gz"
+  FormatTarZst ArchiveFormat = "tar.zst"
+  DefaultFormat = FormatZip
+)
+
+var ArchiveFormats = []string{string(FormatZip), string(FormatTarGz), string(FormatTarZst)}
+
+func (f ArchiveFormat) Ext() (string) {
+  return "." + string(f)
+}
+
+func ArchiveFormatFrom(s string) (ArchiveFormat, error) {
+  for _, f := range ArchiveFormats {
+    if s == f { return ArchiveFormat(f), nil }
+  }
+  return "", fmt.Errorf("Unknown archive format \"%s\": use one of %s", s, strings.Join(ArchiveFormats, ", "))
+}
+
+// Work out the format from the key, ok is false if we can't.
+func FormatFromKey(key string) (format ArchiveFormat, ok bool) {
+  key = strings.TrimSuffix(key, encryptedExt)
+  for _, f := range ArchiveFormats {
+    if strings.HasSuffix(key, "." + f) { return ArchiveFormat(f), true }
+  }
+  return "", false
+}
+
+var(
+  zipMagic = []byte("PK\x03\x04")
+  gzipMagic = []byte{0x1f, 0x8b}
+  zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Work out the format from the first few bytes of the archive.
+func sniffFormat(header []byte) (ArchiveFormat, error) {
+  switch {
+  case bytes.HasPrefix(header, zipMagic): return FormatZip, nil
+  case bytes.HasPrefix(header, gzipMagic): return FormatTarGz, nil
+  case bytes.HasPrefix(header, zstdMagic): return FormatTarZst, nil
+  }
+  return "", fmt.Errorf("Can't tell what kind of archive this is.")
+}
+
+// The extension to publish an archive file under, so that it lists as an archive.
+// From the file's name if that says what it is, otherwise from its first few bytes.
+func FileArchiveExt(name string) (string, error) {
+  if format, ok := FormatFromKey(name); ok {
+    if strings.HasSuffix(name, encryptedExt) { return format.Ext() + encryptedExt, nil }
+    return format.Ext(), nil
+  }
+  file, err := os.Open(name)
+  if err != nil { return "", err }
+  defer file.Close()
+  header := make([]byte, len(zstdMagic))
+  n, err := io.ReadFull(file, header)
+  if err != nil && err != io.ErrUnexpectedEOF { return "", fmt.Errorf("Can't read archive %s: %s", name, err) }
+  format, err := sniffFormat(header[:n])
+  if err != nil { return "", fmt.Errorf("%s: %s", name, err) }
+  return format.Ext(), nil
+}
+
+//
+// Writing
+//
+
+type archiveWriter interface {
+  // Add a directory or regular file, filling in the size and checksum in mf.
+  Add(p string, mf *ManifestFile, info os.FileInfo) (error)
+  Close() (error)
+}
+
+func newArchiveWriter(format ArchiveFormat, w io.Writer) (archiveWriter, error) {
+  switch format {
+  case FormatZip:
+    return &zipArchiveWriter{zw: zip.NewWriter(w)}, nil
+  case FormatTarGz:
+    gw := gzip.NewWriter(w)
+    return &tarArchiveWriter{tw: tar.NewWriter(gw), compressor: gw}, nil
+  case FormatTarZst:
+    zw, err := zstd.NewWriter(w)
+    if err != nil { return nil, err }
+    return &tarArchiveWriter{tw: tar.NewWriter(zw), compressor: zw}, nil
+  }
+  return nil, fmt.Errorf("Unknown archive format \"%s\"", format)
+}
+
+// Copy a file into w, recording its size and checksum.
+func copyHashed(w io.Writer, p string, mf *ManifestFile) (err error) {
+  file, err := os.Open(p)
+  if err != nil { return err }
+  defer file.Close()
+  h := sha256.New()
+  mf.Size, err = io.Copy(io.MultiWriter(w, h), file)
+  mf.SHA256 = hex.EncodeToString(h.Sum(nil))
+  return err
+}
+
+type zipArchiveWriter struct {
+  zw *zip.Writer
+}
+
+func (a *zipArchiveWriter) Add(p string, mf *ManifestFile, info os.FileInfo) (error) {
+  hdr, err := zip.FileInfoHeader(info)
+  if err != nil { return err }
+  hdr.Name = mf.Path
+  if info.IsDir() {
+    hdr.Name += "/"
+    _, err = a.zw.CreateHeader(hdr)
+    return err
+  }
+  hdr.Method = zip.Deflate
+  entry, err := a.zw.CreateHeader(hdr)
+  if err != nil { return err }
+  return copyHashed(entry, p, mf)
+}
+
+func (a *zipArchiveWriter) Close() (error) {
+  return a.zw.Close()
+}
+
+type tarArchiveWriter struct {
+  tw *tar.Writer
+  compressor io.WriteCloser
+}
+
+func (a *tarArchiveWriter) Add(p string, mf *ManifestFile, info os.FileInfo) (error) {
+  hdr, err := tar.FileInfoHeader(info, "")
+  if err != nil { return err }
+  hdr.Name = mf.Path
+  if info.IsDir() {
+    hdr.Name += "/"
+    return a.tw.WriteHeader(hdr)
+  }
+  if err = a.tw.WriteHeader(hdr); err != nil { return err }
+  if err = copyHashed(a.tw, p, mf); err != nil { return err }
+  // The file changed size under us, tar can't cope with that.
+  if mf.Size != hdr.Size { return fmt.Errorf("%s changed size while being archived", mf.Path) }
+  return nil
+}
+
+func (a *tarArchiveWriter) Close() (error) {
+  if err := a.tw.Close(); err != nil { return err }
+  return a.compressor.Close()
+}
+
+// Write the files (or directories) named relative to baseDir into an archive,
+// leaving out anything the rules exclude, and describe each file as it goes in.
+func WriteArchive(w io.Writer, format ArchiveFormat, baseDir string, files []string, rules *IgnoreRules) (contents []ManifestFile, err error) {
+  aw, err := newArchiveWriter(format, w)
+  if err != nil { return nil, err }
+  contents = make([]ManifestFile, 0)
+  err = WalkFiles(baseDir, files, rules, func(p, rel string, info os.FileInfo) (error) {
+    mf := ManifestFile{
+      Path: rel,
+      Mode: info.Mode().Perm(),
+      ModTime: info.ModTime(),
+    }
+    if err := aw.Add(p, &mf, info); err != nil { return err }
+    if !info.IsDir() { contents = append(contents, mf) }
+    return nil
+  })
+  if err != nil { return contents, err }
+  return contents, aw.Close()
+}
+
+//
+// Reading
+//
+
+type archiveEntry struct {
+  Name string
+  Mode os.FileMode
+  ModTime time.Time
+  IsDir bool
+}
+
+// Call fn for each entry in an archive, with a reader for its contents.
+// We'll sniff the format if we're not given one.
+func walkArchive(format ArchiveFormat, r io.Reader, fn func(e archiveEntry, r io.Reader) (error)) (error) {
+  if format == "" {
+    br := bufio.NewReader(r)
+    header, _ := br.Peek(len(zstdMagic))
+    var err error
+    if format, err = sniffFormat(header); err != nil { return err }
+    r = br
+  }
+
+  switch format {
+  case FormatZip:
+    return walkZip(r, fn)
+  case FormatTarGz:
+    gr, err := gzip.NewReader(r)
+    if err != nil { return err }
+    defer gr.Close()
+    return walkTar(gr, fn)
+  case FormatTarZst:
+    zr, err := zstd.NewReader(r)
+    if err != nil { return err }
+    defer zr.Close()
+    return walkTar(zr, fn)
+  }
+  return fmt.Errorf("Unknown archive format \"%s\"", format)
+}
+
+// Zip's directory is at the end, so it has to go to a file first.
+func walkZip(r io.Reader, fn func(e archiveEntry, r io.Reader) (error)) (error) {
+  tmp, err := ioutil.TempFile("", "craft-archive-")
+  if err != nil { return err }
+  defer func() {
+    tmp.Close()
+    os.Remove(tmp.Name())
+  }()
+  if _, err = io.Copy(tmp, r); err != nil { return fmt.Errorf("Couldn't download archive: %s", err) }
+
+  zr, err := zip.OpenReader(tmp.Name())
+  if err != nil { return fmt.Errorf("Couldn't open archive: %s", err) }
+  defer zr.Close()
+  for _, zf := range zr.File {
+    e := archiveEntry{Name: zf.Name, Mode: zf.Mode(), ModTime: zf.Modified, IsDir: zf.FileInfo().IsDir()}
+    if e.IsDir {
+      if err = fn(e, nil); err != nil { return err }
+      continue
+    }
+    rc, err := zf.Open()
+    if err != nil { return err }
+    err = fn(e, rc)
+    rc.Close()
+    if err != nil { return err }
+  }
+  return nil
+}
+
+func walkTar(r io.Reader, fn func(e archiveEntry, r io.Reader) (error)) (error) {
+  tr := tar.NewReader(r)
+  for {
+    hdr, err := tr.Next()
+    if err == io.EOF { return nil }
+    if err != nil { return err }
+    e := archiveEntry{Name: hdr.Name, Mode: hdr.FileInfo().Mode(), ModTime: hdr.ModTime}
+    switch hdr.Typeflag {
+    case tar.TypeDir:
+      e.IsDir = true
+      err = fn(e, nil)
+    case tar.TypeReg:
+      err = fn(e, tr)
+    }
+    if err != nil { return err }
+  }
+}
+
+// Unpack an archive into destDir, refusing entries that would land outside of it.
+func extractArchive(format ArchiveFormat, r io.Reader, destDir string) (files int, err error) {
+  if err = os.MkdirAll(destDir, 0755); err != nil { return 0, err }
+  err = walkArchive(format, r, func(e archiveEntry, r io.Reader) (error) {
+    path, err := safeJoin(destDir, e.Name)
+    if err != nil { return err }
+    if e.IsDir { return os.MkdirAll(path, e.Mode.Perm() | 0700) }
+    if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil { return err }
+    if err = extractFile(r, path, e); err != nil { return err }
+    files++
+    return nil
+  })
+  return files, err
+}
+
+func extractFile(r io.Reader, path string, e archiveEntry) (error) {
+  out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, e.Mode.Perm() | 0600)
+  if err != nil { return err }
+  if _, err = io.Copy(out, r); err != nil {
+    out.Close()
+    return err
+  }
+  if err = out.Close(); err != nil { return err }
+  if e.ModTime.IsZero() { return nil }
+  return os.Chtimes(path, e.ModTime, e.ModTime)
+}
+
+// Size and checksum of every file in an archive.
+func hashArchiveEntries(format ArchiveFormat, r io.Reader) (map[string]ManifestFile, error) {
+  found := make(map[string]ManifestFile)
+  err := walkArchive(format, r, func(e archiveEntry, r io.Reader) (error) {
+    if e.IsDir { return nil }
+    h := sha256.New()
+    size, err := io.Copy(h, r)
+    if err != nil { return fmt.Errorf("%s: %s", e.Name, err) }
+    found[e.Name] = ManifestFile{Path: e.Name, Size: size, SHA256: hex.EncodeToString(h.Sum(nil))}
+    return nil
+  })
+  return found, err
+}
@@ -0,0 +1,93 @@
+package lib
+
+import (
+  "bytes"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestArchiveFormatsRoundTrip(t *testing.T) {
+  dir, err := ioutil.TempDir("", "format-test")
+  assert.NoError(t, err)
+  defer os.RemoveAll(dir)
+
+  serverDir := filepath.Join(dir, "server")
+  assert.NoError(t, os.MkdirAll(filepath.Join(serverDir, "world", "region"), 0755))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "world", "level.dat"), []byte("level"), 0644))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "world", "region", "r.0.0.mca"), bytes.Repeat([]byte("chunk"), 1000), 0644))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "world", "session.lock"), []byte("lock"), 0644))
+  rules, err := NewIgnoreRules([]string{"session.lock"})
+  assert.NoError(t, err)
+
+  for _, name := range ArchiveFormats {
+    format := ArchiveFormat(name)
+    var buf bytes.Buffer
+    contents, err := WriteArchive(&buf, format, serverDir, []string{"world"}, rules)
+    assert.NoError(t, err, name)
+    assert.Equal(t, 2, len(contents), name)
+
+    sniffed, err := sniffFormat(buf.Bytes())
+    assert.NoError(t, err, name)
+    assert.Equal(t, format, sniffed)
+
+    // Restore without telling it the format.
+    dest := filepath.Join(dir, "restore-" + name)
+    files, err := extractArchive("", bytes.NewReader(buf.Bytes()), dest)
+    assert.NoError(t, err, name)
+    assert.Equal(t, 2, files, name)
+    b, err := ioutil.ReadFile(filepath.Join(dest, "world", "level.dat"))
+    assert.NoError(t, err, name)
+    assert.Equal(t, "level", string(b))
+    _, err = os.Stat(filepath.Join(dest, "world", "session.lock"))
+    assert.True(t, os.IsNotExist(err), name)
+
+    found, err := hashArchiveEntries(format, bytes.NewReader(buf.Bytes()))
+    assert.NoError(t, err, name)
+    for _, mf := range contents {
+      assert.Equal(t, mf.SHA256, found[mf.Path].SHA256, name)
+    }
+  }
+}
+
+func TestFormatFromKey(t *testing.T) {
+  f, ok := FormatFromKey("u/s/WorldSnapshot/2017-01-01T00-00-00.000Z.tar.zst")
+  assert.True(t, ok)
+  assert.Equal(t, FormatTarZst, f)
+  _, ok = FormatFromKey("u/s/WorldSnapshot/2017-01-01T00-00-00.000Z.incremental.json")
+  assert.False(t, ok)
+  _, err := ArchiveFormatFrom("rar")
+  assert.Error(t, err)
+}
+
+func TestFileArchiveExt(t *testing.T) {
+  dir, err := ioutil.TempDir("", "archive-ext")
+  assert.NoError(t, err)
+  defer os.RemoveAll(dir)
+  write := func(name string, b []byte) (string) {
+    name = filepath.Join(dir, name)
+    assert.NoError(t, ioutil.WriteFile(name, b, 0644))
+    return name
+  }
+
+  for name, ext := range map[string]string{
+    "server.tar.gz": ".tar.gz",
+    "server.tar.zst": ".tar.zst",
+    "server.zip": ".zip",
+    "server.tar.zst.age": ".tar.zst.age",
+  } {
+    got, err := FileArchiveExt(write(name, []byte("contents")))
+    assert.NoError(t, err, name)
+    assert.Equal(t, ext, got, name)
+    assert.True(t, isArchiveKey("u/s/MiscSnapshot/1" + got), name)
+  }
+
+  // Nothing in the name, so look at what's in it.
+  got, err := FileArchiveExt(write("backup", append([]byte{}, zstdMagic...)))
+  assert.NoError(t, err)
+  assert.Equal(t, ".tar.zst", got)
+  _, err = FileArchiveExt(write("notes.txt", []byte("x")))
+  assert.Error(t, err)
+}
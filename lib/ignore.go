@@ -0,0 +1,175 @@
+package lib
+
+import(
+  "bufio"
+  "fmt"
+  "os"
+  "path"
+  "path/filepath"
+  "regexp"
+  "strings"
+)
+
+// Exclude rules for snapshots and the file watcher, in the style of .gitignore:
+//   # comment
+//   logs/              a directory (and everything in it) at any depth
+//   *.log              a name at any depth
+//   /world/session.lock  anchored to the server directory
+//   world/**/*.tmp     ** matches any number of directories
+//   !logs/latest.log   negates an earlier rule
+// Later rules win. As with git, nothing inside an excluded directory
+// can be brought back with a negation.
+const IgnoreFileName = ".craftignore"
+
+// Always left out: nothing a restored server needs, and the lock the
+// running server holds on its world.
+var DefaultIgnorePatterns = []string{".git/", IgnoreFileName, "logs/", "crash-reports/", "world/session.lock"}
+
+type IgnoreRules struct {
+  rules []ignoreRule
+}
+
+type ignoreRule struct {
+  pattern string
+  negate bool
+  dirOnly bool
+  re *regexp.Regexp
+}
+
+func NewIgnoreRules(patterns []string) (*IgnoreRules, error) {
+  ir := &IgnoreRules{}
+  for _, p := range patterns {
+    if err := ir.Add(p); err != nil { return nil, err }
+  }
+  return ir, nil
+}
+
+// The defaults, then the server directory's .craftignore, then any extra patterns.
+func LoadIgnoreRules(serverDir string, extra []string) (*IgnoreRules, error) {
+  ir, err := NewIgnoreRules(DefaultIgnorePatterns)
+  if err != nil { return nil, err }
+
+  file, err := os.Open(filepath.Join(serverDir, IgnoreFileName))
+  if err == nil {
+    defer file.Close()
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+      if err = ir.Add(scanner.Text()); err != nil {
+        return nil, fmt.Errorf("Bad rule in %s: %s", IgnoreFileName, err)
+      }
+    }
+    if err = scanner.Err(); err != nil { return nil, err }
+  } else if !os.IsNotExist(err) {
+    return nil, err
+  }
+
+  for _, p := range extra {
+    if err = ir.Add(p); err != nil { return nil, err }
+  }
+  return ir, nil
+}
+
+// Add a single rule. Blank lines and comments are ignored.
+func (ir *IgnoreRules) Add(pattern string) (error) {
+  p := strings.TrimRight(pattern, " \t\r")
+  if p == "" || strings.HasPrefix(p, "#") { return nil }
+
+  r := ignoreRule{pattern: p}
+  if strings.HasPrefix(p, "!") {
+    r.negate = true
+    p = p[1:]
+  } else if strings.HasPrefix(p, `\`) {
+    p = p[1:]
+  }
+  if strings.HasSuffix(p, "/") {
+    r.dirOnly = true
+    p = strings.TrimRight(p, "/")
+  }
+  // A slash anywhere but the end anchors the pattern to the base directory.
+  anchored := strings.Contains(p, "/")
+  p = strings.TrimPrefix(p, "/")
+  if p == "" { return fmt.Errorf("Empty exclude pattern: \"%s\"", pattern) }
+
+  expr := globToRegexp(p)
+  if anchored {
+    expr = "^" + expr + "$"
+  } else {
+    expr = "^(?:.*/)?" + expr + "$"
+  }
+  re, err := regexp.Compile(expr)
+  if err != nil { return fmt.Errorf("Bad exclude pattern \"%s\": %s", pattern, err) }
+  r.re = re
+  ir.rules = append(ir.rules, r)
+  return nil
+}
+
+func globToRegexp(glob string) (string) {
+  var b strings.Builder
+  for i := 0; i < len(glob); i++ {
+    c := glob[i]
+    switch {
+    case strings.HasPrefix(glob[i:], "**/"):
+      b.WriteString("(?:.*/)?")
+      i += 2
+    case strings.HasPrefix(glob[i:], "/**") && i + 3 == len(glob):
+      b.WriteString("/.*")
+      i += 2
+    case strings.HasPrefix(glob[i:], "**"):
+      b.WriteString(".*")
+      i++
+    case c == '*':
+      b.WriteString("[^/]*")
+    case c == '?':
+      b.WriteString("[^/]")
+    default:
+      b.WriteString(regexp.QuoteMeta(string(c)))
+    }
+  }
+  return b.String()
+}
+
+func (ir *IgnoreRules) match(rel string, isDir bool) (bool) {
+  ignored := false
+  for _, r := range ir.rules {
+    if r.dirOnly && !isDir { continue }
+    if r.re.MatchString(rel) { ignored = !r.negate }
+  }
+  return ignored
+}
+
+// Is the slash separated path, relative to the base directory, excluded?
+// A path is excluded if it, or any directory above it, matches.
+func (ir *IgnoreRules) Ignored(rel string, isDir bool) (bool) {
+  if ir == nil { return false }
+  rel = strings.Trim(path.Clean(filepath.ToSlash(rel)), "/")
+  if rel == "." || rel == "" { return false }
+  parts := strings.Split(rel, "/")
+  for i := 1; i < len(parts); i++ {
+    if ir.match(strings.Join(parts[:i], "/"), true) { return true }
+  }
+  return ir.match(rel, isDir)
+}
+
+// Walk the files (or directories) named relative to baseDir, calling fn
+// for each directory and regular file that isn't excluded. Excluded
+// directories aren't descended into.
+func WalkFiles(baseDir string, files []string, rules *IgnoreRules, fn func(p, rel string, info os.FileInfo) (error)) (error) {
+  for _, name := range files {
+    root := filepath.Join(baseDir, name)
+    err := filepath.Walk(root, func(p string, info os.FileInfo, err error) (error) {
+      if err != nil { return err }
+      rel, err := filepath.Rel(baseDir, p)
+      if err != nil { return err }
+      if rel == "." { return nil }
+      rel = filepath.ToSlash(rel)
+      if rules.Ignored(rel, info.IsDir()) {
+        if info.IsDir() { return filepath.SkipDir }
+        return nil
+      }
+      if !info.Mode().IsRegular() && !info.IsDir() { return nil }
+      return fn(p, rel, info)
+    })
+    if err != nil { return err }
+  }
+  return nil
+}
@@ -0,0 +1,36 @@
+package lib
+
+import (
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestIgnoreRules(t *testing.T) {
+  ir, err := NewIgnoreRules(append(DefaultIgnorePatterns,
+    "# server junk",
+    "*.tmp",
+    "!keep.tmp",
+    "world/**/*.bak",
+  ))
+  assert.NoError(t, err)
+
+  assert.True(t, ir.Ignored(".git", true))
+  assert.True(t, ir.Ignored(".git/objects/ab/cdef", false))
+  assert.True(t, ir.Ignored("logs", true))
+  assert.True(t, ir.Ignored("logs/latest.log", false))
+  assert.True(t, ir.Ignored("plugins/foo/logs/x.log", false))
+  assert.False(t, ir.Ignored("logs", false), "logs/ only matches directories")
+  assert.True(t, ir.Ignored("world/session.lock", false))
+  assert.False(t, ir.Ignored("world_nether/session.lock", false))
+  assert.False(t, ir.Ignored("other/world/session.lock", false), "anchored patterns only match at the base")
+  assert.True(t, ir.Ignored("world/region/r.0.0.tmp", false))
+  assert.False(t, ir.Ignored("world/keep.tmp", false))
+  assert.True(t, ir.Ignored("world/a/b/c.bak", false))
+  assert.True(t, ir.Ignored("world/c.bak", false))
+  assert.False(t, ir.Ignored("world/region/r.0.0.mca", false))
+  assert.False(t, ir.Ignored("server.properties", false))
+  assert.True(t, ir.Ignored("crash-reports/crash-2017-01-01_00.00.00-server.txt", false))
+
+  var none *IgnoreRules
+  assert.False(t, none.Ignored("logs/latest.log", false))
+}
@@ -0,0 +1,174 @@
+package lib
+
+import(
+  "context"
+  "crypto/sha256"
+  "encoding/hex"
+  "fmt"
+  "io"
+  "os"
+  "path"
+  "path/filepath"
+  "strings"
+  "time"
+  "github.com/Sirupsen/logrus"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+// Incremental snapshots store each file once, by the SHA-256 of its contents:
+//   <user>/<server>/blobs/<sha[0:2]>/<sha>
+// and each snapshot is just a manifest pointing at the blobs:
+//   <user>/<server>/<ArchiveType>/<timestamp>-<random>.incremental.json
+// A snapshot uploads its blobs before its manifest, so until it's done
+// nothing refers to them. Blobs newer than BlobGracePeriod aren't collected.
+const(
+  incrementalExt = ".incremental.json"
+  blobsDir = "blobs"
+  BlobGracePeriod = time.Hour
+)
+
+func IsIncrementalKey(key string) (bool) {
+  return strings.HasSuffix(key, incrementalExt)
+}
+
+// Blobs live in the server's directory, two levels up from a manifest.
+func blobPrefix(manifestKey string) (string) {
+  return path.Join(path.Dir(path.Dir(manifestKey)), blobsDir)
+}
+
+func blobKey(prefix, sum string) (string) {
+  return path.Join(prefix, sum[0:2], sum)
+}
+
+func HashFile(p string) (sum string, size int64, err error) {
+  file, err := os.Open(p)
+  if err != nil { return "", 0, err }
+  defer file.Close()
+  h := sha256.New()
+  size, err = io.Copy(h, file)
+  if err != nil { return "", 0, err }
+  return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// Upload the blobs the store doesn't already have and then the manifest.
+func putIncrementalSnapshot(ctx context.Context, s *mclib.Server, aType mclib.ArchiveType, files []string, rules *IgnoreRules, store ArchiveStore) (result *SnapshotResult, err error) {
+  f := s.LogFields()
+  f["snapshotType"] = aType.String()
+  f["operation"] = "Snapshot"
+
+  key := ArchiveKey(s.User, s.Name, aType, time.Now(), incrementalExt)
+  prefix := blobPrefix(key)
+  existing, err := store.List(prefix + "/")
+  if err != nil { return nil, err }
+  have := make(map[string]bool, len(existing))
+  for _, obj := range existing {
+    have[path.Base(obj.Key)] = true
+  }
+
+  manifest := NewManifest(s, aType)
+  manifest.Incremental = true
+  uploaded, uploadedBytes := 0, int64(0)
+  err = WalkFiles(s.ServerDirectory, files, rules, func(p, rel string, info os.FileInfo) (error) {
+    if err := ctx.Err(); err != nil { return err }
+    if info.IsDir() { return nil }
+    sum, size, err := HashFile(p)
+    if err != nil { return err }
+    manifest.Files = append(manifest.Files, ManifestFile{
+      Path: rel,
+      Size: size,
+      SHA256: sum,
+      Mode: info.Mode().Perm(),
+      ModTime: info.ModTime(),
+    })
+    if have[sum] { return nil }
+
+    file, err := os.Open(p)
+    if err != nil { return err }
+    defer file.Close()
+    if _, err = store.Put(blobKey(prefix, sum), file); err != nil { return err }
+    have[sum] = true
+    uploaded++
+    uploadedBytes += size
+    return nil
+  })
+  if err != nil { return nil, err }
+
+  obj, err := putManifest(store, key, manifest, nil)
+  if err != nil { return nil, err }
+
+  f["files"] = len(manifest.Files)
+  f["newBlobs"] = uploaded
+  f["newBlobBytes"] = uploadedBytes
+  log.Debug(f, "Stored incremental snapshot.")
+  return &SnapshotResult{
+    Type: aType,
+    URI: store.URI(key),
+    Files: len(manifest.Files),
+    Object: obj,
+    Uploaded: uploadedBytes,
+  }, nil
+}
+
+// Rebuild the full tree described by an incremental manifest in destDir.
+func restoreIncremental(store ArchiveStore, key, destDir string) (files int, err error) {
+  m, err := GetManifest(store, key, nil)
+  if err != nil { return 0, err }
+  prefix := blobPrefix(key)
+  if err = os.MkdirAll(destDir, 0755); err != nil { return 0, err }
+  for _, mf := range m.Files {
+    p, err := safeJoin(destDir, mf.Path)
+    if err != nil { return files, err }
+    if err = os.MkdirAll(filepath.Dir(p), 0755); err != nil { return files, err }
+    if err = restoreBlob(store, blobKey(prefix, mf.SHA256), p, mf); err != nil { return files, err }
+    files++
+  }
+  return files, nil
+}
+
+func restoreBlob(store ArchiveStore, key, p string, mf ManifestFile) (error) {
+  r, err := store.Get(key)
+  if err != nil { return err }
+  defer r.Close()
+  out, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mf.Mode | 0600)
+  if err != nil { return err }
+  h := sha256.New()
+  _, err = io.Copy(io.MultiWriter(out, h), r)
+  if cerr := out.Close(); err == nil { err = cerr }
+  if err != nil { return err }
+  if sum := hex.EncodeToString(h.Sum(nil)); sum != mf.SHA256 {
+    return fmt.Errorf("Blob for %s is corrupt: expected %s got %s", mf.Path, mf.SHA256, sum)
+  }
+  return os.Chtimes(p, mf.ModTime, mf.ModTime)
+}
+
+// Remove the blobs stored before cutoff that no remaining incremental
+// manifest of the server refers to.
+func CollectGarbageBlobs(store ArchiveStore, user, server string, cutoff time.Time) (removed int, err error) {
+  prefix := path.Join(user, server, blobsDir)
+  objects, err := store.List(path.Join(user, server) + "/")
+  if err != nil { return 0, err }
+
+  inUse := make(map[string]bool)
+  for _, obj := range objects {
+    if !IsIncrementalKey(obj.Key) { continue }
+    m, err := GetManifest(store, obj.Key, nil)
+    if err != nil { return 0, err }
+    for _, mf := range m.Files {
+      inUse[mf.SHA256] = true
+    }
+  }
+
+  f := logrus.Fields{"operation": "Prune", "userName": user, "serverName": server}
+  for _, obj := range objects {
+    if !strings.HasPrefix(obj.Key, prefix + "/") || inUse[path.Base(obj.Key)] { continue }
+    // Perhaps a snapshot that hasn't stored its manifest yet.
+    if !obj.LastMod.Before(cutoff) { continue }
+    if err = store.Delete(obj.Key); err != nil { return removed, err }
+    removed++
+  }
+  f["blobsRemoved"] = removed
+  log.Debug(f, "Collected unreferenced blobs.")
+  return removed, nil
+}
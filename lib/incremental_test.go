@@ -0,0 +1,68 @@
+package lib
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+  "github.com/stretchr/testify/assert"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+func TestIncrementalSnapshotAndRestore(t *testing.T) {
+  dir, err := ioutil.TempDir("", "incremental-test")
+  assert.NoError(t, err)
+  defer os.RemoveAll(dir)
+
+  serverDir := filepath.Join(dir, "server")
+  assert.NoError(t, os.MkdirAll(filepath.Join(serverDir, "world", "region"), 0755))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "world", "level.dat"), []byte("level"), 0644))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "world", "region", "r.0.0.mca"), []byte("region"), 0644))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "world", "region", "r.0.1.mca"), []byte("region"), 0644))
+
+  store := NewFileStore(filepath.Join(dir, "archives"))
+  s := &mclib.Server{User: "testuser", Name: "testserver", ServerDirectory: serverDir}
+  opts := SnapshotOptions{Incremental: true}
+
+  first, err := TakeSnapshot(s, mclib.WorldSnapshot, store, opts)
+  assert.NoError(t, err)
+  assert.Equal(t, 3, first.Files)
+  blobs, _ := store.List("testuser/testserver/blobs/")
+  // Two of the files have the same contents.
+  assert.Equal(t, 2, len(blobs))
+
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "world", "region", "r.0.1.mca"), []byte("changed"), 0644))
+  second, err := TakeSnapshot(s, mclib.WorldSnapshot, store, opts)
+  assert.NoError(t, err)
+  blobs, _ = store.List("testuser/testserver/blobs/")
+  assert.Equal(t, 3, len(blobs))
+  // Only "changed" was new.
+  assert.Equal(t, int64(7), second.Uploaded)
+
+  al, err := ListArchives(store, "testuser")
+  assert.NoError(t, err)
+  assert.Equal(t, 2, len(al))
+
+  // Restore the first snapshot over the changed server.
+  result, err := RestoreArchive(first.URI, "127.0.0.1", mclib.Port(1), serverDir, nil, nil)
+  if assert.NoError(t, err) {
+    assert.Equal(t, 3, result.Files)
+    b, _ := ioutil.ReadFile(filepath.Join(serverDir, "world", "region", "r.0.1.mca"))
+    assert.Equal(t, "region", string(b))
+    assert.True(t, strings.HasPrefix(result.PreviousDirectory, serverDir + ".previous-"))
+  }
+
+  // Dropping the second snapshot should only collect the blob only it used,
+  // and not while it's new enough to belong to a snapshot still running.
+  assert.NoError(t, store.Delete(second.Object.Key))
+  removed, err := CollectGarbageBlobs(store, "testuser", "testserver", time.Now().Add(-BlobGracePeriod))
+  assert.NoError(t, err)
+  assert.Equal(t, 0, removed)
+  removed, err = CollectGarbageBlobs(store, "testuser", "testserver", time.Now().Add(time.Second))
+  assert.NoError(t, err)
+  assert.Equal(t, 1, removed)
+}
@@ -0,0 +1,214 @@
+package lib
+
+import(
+  "bufio"
+  "bytes"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io"
+  "os"
+  "path/filepath"
+  "regexp"
+  "sort"
+  "time"
+  "craft-config/version"
+  "github.com/aws/aws-sdk-go/aws/session"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+// Every snapshot gets a manifest describing what's in it.
+// Archive files have theirs published next to them as <key>.manifest.json,
+// for incremental snapshots the manifest is the snapshot. The manifest of
+// an encrypted archive is encrypted too.
+const(
+  manifestExt = ".manifest.json"
+  manifestVersion = 1
+)
+
+type Manifest struct {
+  Version int `json:"version"`
+  UserName string `json:"userName"`
+  ServerName string `json:"serverName"`
+  Type string `json:"type"`
+  Created time.Time `json:"created"`
+  Incremental bool `json:"incremental"`
+  CraftType string `json:"craftType"`
+  MinecraftVersion string `json:"minecraftVersion"`
+  LevelName string `json:"levelName"`
+  ControllerVersion string `json:"controllerVersion"`
+  Files []ManifestFile `json:"files"`
+}
+
+type ManifestFile struct {
+  Path string `json:"path"`
+  Size int64 `json:"size"`
+  SHA256 string `json:"sha256"`
+  Mode os.FileMode `json:"mode"`
+  ModTime time.Time `json:"modTime"`
+}
+
+func ManifestKey(archiveKey string) (string) {
+  return archiveKey + manifestExt
+}
+
+func NewManifest(s *mclib.Server, aType mclib.ArchiveType) (*Manifest) {
+  return &Manifest{
+    Version: manifestVersion,
+    UserName: s.User,
+    ServerName: s.Name,
+    Type: aType.String(),
+    Created: time.Now().UTC(),
+    CraftType: fmt.Sprintf("%v", s.CraftType()),
+    MinecraftVersion: MinecraftVersion(s.ServerDirectory),
+    LevelName: LevelName(s.ServerDirectory),
+    ControllerVersion: version.Version.String(),
+    Files: make([]ManifestFile, 0),
+  }
+}
+
+var mcVersionRe = regexp.MustCompile(`Starting minecraft server version (\S+)`)
+
+// The version the server reported the last time it started,
+// empty if we can't find it in logs/latest.log.
+func MinecraftVersion(serverDir string) (string) {
+  file, err := os.Open(filepath.Join(serverDir, "logs", "latest.log"))
+  if err != nil { return "" }
+  defer file.Close()
+  scanner := bufio.NewScanner(file)
+  for scanner.Scan() {
+    if m := mcVersionRe.FindStringSubmatch(scanner.Text()); m != nil {
+      return m[1]
+    }
+  }
+  return ""
+}
+
+func putManifest(store ArchiveStore, key string, m *Manifest, enc *Encryption) (*StoredObject, error) {
+  b, err := json.MarshalIndent(m, "", "  ")
+  if err != nil { return nil, err }
+  if enc.Encrypts() {
+    var buf bytes.Buffer
+    w, err := enc.Encrypt(&buf)
+    if err != nil { return nil, err }
+    if _, err = w.Write(b); err != nil { return nil, err }
+    if err = w.Close(); err != nil { return nil, err }
+    b = buf.Bytes()
+  }
+  return store.Put(key, bytes.NewReader(b))
+}
+
+// Encrypted manifests are decrypted with enc.
+func GetManifest(store ArchiveStore, key string, enc *Encryption) (*Manifest, error) {
+  rc, err := store.Get(key)
+  if err != nil { return nil, err }
+  defer rc.Close()
+  r, err := decryptIfNeeded(rc, enc)
+  if err != nil { return nil, fmt.Errorf("Couldn't read manifest %s: %s", store.URI(key), err) }
+  m := &Manifest{}
+  if err = json.NewDecoder(r).Decode(m); err != nil {
+    return nil, fmt.Errorf("Couldn't read manifest %s: %s", store.URI(key), err)
+  }
+  return m, nil
+}
+
+//
+// Verification
+//
+
+type VerifyReport struct {
+  URI string
+  Manifest *Manifest
+  Checked int
+  Problems []string
+}
+
+func (r *VerifyReport) OK() (bool) {
+  return len(r.Problems) == 0
+}
+
+func (r *VerifyReport) problem(format string, args ...interface{}) {
+  r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// Download an archive and check it against its manifest,
+// decrypting both with enc if they're encrypted.
+// Errors are for when we can't do the check at all, what's wrong
+// with the archive ends up in the report.
+func VerifyArchive(uri string, sess *session.Session, enc *Encryption) (report *VerifyReport, err error) {
+  store, key, err := OpenArchiveURI(uri, sess)
+  if err != nil { return nil, err }
+  report = &VerifyReport{URI: uri}
+
+  if IsIncrementalKey(key) {
+    report.Manifest, err = GetManifest(store, key, nil)
+    if err != nil { return nil, err }
+    verifyBlobs(store, key, report)
+    return report, nil
+  }
+
+  report.Manifest, err = GetManifest(store, ManifestKey(key), enc)
+  if err != nil { return nil, fmt.Errorf("No manifest for %s: %s", uri, err) }
+
+  format, _ := FormatFromKey(key)
+  rc, err := store.Get(key)
+  if err != nil { return nil, err }
+  defer rc.Close()
+  r, err := decryptIfNeeded(rc, enc)
+  if err != nil { return nil, err }
+  found, err := hashArchiveEntries(format, r)
+  if err != nil {
+    report.problem("Archive can't be read: %s", err)
+    return report, nil
+  }
+  compareManifest(report, found)
+  return report, nil
+}
+
+func verifyBlobs(store ArchiveStore, key string, report *VerifyReport) {
+  prefix := blobPrefix(key)
+  for _, mf := range report.Manifest.Files {
+    report.Checked++
+    r, err := store.Get(blobKey(prefix, mf.SHA256))
+    if err != nil {
+      report.problem("%s: missing blob %s", mf.Path, mf.SHA256)
+      continue
+    }
+    h := sha256.New()
+    size, err := io.Copy(h, r)
+    r.Close()
+    if err != nil {
+      report.problem("%s: can't read blob: %s", mf.Path, err)
+    } else if sum := hex.EncodeToString(h.Sum(nil)); sum != mf.SHA256 || size != mf.Size {
+      report.problem("%s: blob is corrupt, expected %s (%d bytes) got %s (%d bytes)", mf.Path, mf.SHA256, mf.Size, sum, size)
+    }
+  }
+}
+
+func compareManifest(report *VerifyReport, found map[string]ManifestFile) {
+  listed := make(map[string]bool, len(report.Manifest.Files))
+  for _, mf := range report.Manifest.Files {
+    listed[mf.Path] = true
+    report.Checked++
+    af, ok := found[mf.Path]
+    switch {
+    case !ok:
+      report.problem("%s: missing from archive", mf.Path)
+    case af.Size != mf.Size:
+      report.problem("%s: size is %d, manifest says %d", mf.Path, af.Size, mf.Size)
+    case af.SHA256 != mf.SHA256:
+      report.problem("%s: checksum is %s, manifest says %s", mf.Path, af.SHA256, mf.SHA256)
+    }
+  }
+  extra := make([]string, 0)
+  for p := range found {
+    if !listed[p] { extra = append(extra, p) }
+  }
+  sort.Strings(extra)
+  for _, p := range extra {
+    report.problem("%s: in archive but not in manifest", p)
+  }
+}
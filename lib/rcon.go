@@ -1,8 +1,11 @@
 package lib
 
 import(
+  "bufio"
+  "encoding/json"
   "fmt"
   "io"
+  "os"
   "regexp"
   "strconv"
   "strings"
@@ -17,28 +20,106 @@ import(
 // Blocks on reading commands and writing input to the stdin/out
 func RconLoop(serverIp string, rconPort mclib.Port, rconPassword string) (error) {
 
-  rcon, err := mclib.NewRcon(serverIp, rconPort.String(), rconPassword)    
+  rcon, err := NewRconClient(serverIp, rconPort, rconPassword)
   if err != nil {return err}
+  defer rcon.Close()
+
+  addr := serverIp + ":" + rconPort.String()
+  // Without help we can still complete quit and exit.
+  help, err := LoadRconHelp(rcon, DefaultHelpCacheDir(), addr)
+  if err != nil { help = "" }
+  completer := NewRconCompleter(rcon, help)
 
   prompt := fmt.Sprintf("%s%s:%s%s: ", EmphColor, serverIp, rconPort, ResetColor)
-  err = PromptLoop(prompt, func(line string) (error) {
+  err = PromptLoopWithCompleter(prompt, completer, rconProcessor(rcon, os.Stdout, addr))
+
+  return err
+
+}
+
+// Sends each line to the server and writes the response to out.
+func rconProcessor(rcon RconSender, out io.Writer, name string) (func(string) (error)) {
+  return func(line string) (error) {
     if strings.Compare(line, "quit") == 0 || strings.Compare(line, "exit") == 0 {return io.EOF}
-    if strings.Compare(line, "stop") == 0 || strings.Compare(line, "end") == 0 {
-      return fmt.Errorf("Can't shutdown the server from here")
-    }
+    if isStopCommand(line) { return errCantStop }
 
     resp, err := rcon.Send(line)
     if err != nil { return err }
     if debug { 
       rs := strconv.Quote(resp) 
-      fmt.Printf("%s%s:%s [RAW]%s: %s\n", EmphColor, serverIp, rconPort, ResetColor, rs)
+      fmt.Fprintf(out, "%s%s [RAW]%s: %s\n", EmphColor, name, ResetColor, rs)
     }
-    fmt.Printf("%s\n", formatRconResp(resp))
+    fmt.Fprintf(out, "%s\n", formatRconResp(resp))
+    return err
+  }
+}
+
+var errCantStop = fmt.Errorf("Can't shutdown the server from here")
+
+func isStopCommand(cmd string) (bool) {
+  return strings.Compare(cmd, "stop") == 0 || strings.Compare(cmd, "end") == 0
+}
+
+// What servers say about commands they don't know or can't parse.
+var rconRefusals = []string{"Unknown or incomplete command", "Unknown command", "Incorrect argument for command"}
+
+// How RconCommand writes responses.
+const(
+  OutputText = "text"
+  OutputJSON = "json"
+)
+
+var OutputFormats = []string{OutputText, OutputJSON}
+
+// What RconCommand writes, one line each, with OutputJSON.
+// Result is there for commands ParseResponse understands.
+type CommandOutput struct {
+  Command string `json:"command"`
+  Response string `json:"response"`
+  Result interface{} `json:"result,omitempty"`
+  Error string `json:"error,omitempty"`
+}
+
+// Send one command and write the cleaned response to out, as text or
+// JSON. It's an error if the server doesn't understand the command.
+func RconCommand(rcon RconSender, cmd string, out io.Writer, output string) (error) {
+  if isStopCommand(cmd) { return errCantStop }
+  resp, err := rcon.Send(cmd)
+  if err != nil { return err }
+  resp = formatRconResp(resp)
+  for _, refusal := range rconRefusals {
+    if strings.HasPrefix(resp, refusal) { err = fmt.Errorf("Server refused \"%s\": %s", cmd, resp) }
+  }
+  if output != OutputJSON {
+    fmt.Fprintf(out, "%s\n", resp)
     return err
-  })
+  }
 
+  co := CommandOutput{Command: cmd, Response: resp}
+  if err == nil {
+    var perr error
+    if co.Result, _, perr = ParseResponse(cmd, resp); perr != nil {
+      co.Result = nil
+      co.Error = perr.Error()
+    }
+  } else {
+    co.Error = err.Error()
+  }
+  if jerr := json.NewEncoder(out).Encode(co); jerr != nil && err == nil { err = jerr }
   return err
+}
 
+// Send each line of r as a command, stopping at the first that fails or
+// at quit or exit. Blank lines and lines starting with # are skipped.
+func RconBatch(rcon RconSender, r io.Reader, out io.Writer, output string) (error) {
+  scanner := bufio.NewScanner(r)
+  for n := 1; scanner.Scan(); n++ {
+    line := strings.TrimSpace(scanner.Text())
+    if line == "" || strings.HasPrefix(line, "#") { continue }
+    if line == "quit" || line == "exit" { return nil }
+    if err := RconCommand(rcon, line, out, output); err != nil { return fmt.Errorf("Line %d: %s", n, err) }
+  }
+  return scanner.Err()
 }
 
 // Takes the color coding out.
@@ -50,15 +131,29 @@ func formatRconResp(r string) (s string) {
 
 
 func PromptLoop(prompt string, process func(string) (error)) (err error) {
+  return promptLoop(prompt, readline.Line, readline.AddHistory, process)
+}
+
+// As PromptLoop, with tab completion.
+func PromptLoopWithCompleter(prompt string, completer readline.AutoCompleter, process func(string) (error)) (error) {
+  rl, err := readline.NewEx(&readline.Config{Prompt: prompt, AutoComplete: completer})
+  if err != nil { return err }
+  defer rl.Close()
+  // The instance keeps its own history.
+  readLine := func(string) (string, error) { return rl.Readline() }
+  return promptLoop(prompt, readLine, func(string) (error) { return nil }, process)
+}
+
+func promptLoop(prompt string, readLine func(string) (string, error), addHistory func(string) (error), process func(string) (error)) (err error) {
   errStr := "Error - %s.\n"
   for moreCommands := true; moreCommands; {
-    line, err := readline.Line(prompt)
+    line, err := readLine(prompt)
     if err == io.EOF {
       moreCommands = false
     } else if err != nil {
       fmt.Printf(errStr, err)
     } else {
-      readline.AddHistory(line)
+      addHistory(line)
       err = process(line)
       if err == io.EOF {
         moreCommands = false
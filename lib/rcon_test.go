@@ -0,0 +1,78 @@
+package lib
+
+import (
+  "bytes"
+  "context"
+  "io"
+  "strings"
+  "testing"
+  "craft-config/lib/rcontest"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestRconProcessor(t *testing.T) {
+  srv, err := rcontest.NewServer("secret")
+  if !assert.NoError(t, err) { return }
+  defer srv.Close()
+  srv.Respond("seed", "Seed: [§a1234§r]")
+
+  c, err := DialRcon(context.Background(), srv.Addr(), "secret")
+  if !assert.NoError(t, err) { return }
+  defer c.Close()
+
+  out := &bytes.Buffer{}
+  process := rconProcessor(c, out, srv.Addr())
+  assert.NoError(t, process("seed"))
+  assert.Equal(t, "Seed: [1234]\n", out.String())
+
+  // Never sent.
+  assert.Error(t, process("stop"))
+  assert.Equal(t, io.EOF, process("quit"))
+  assert.Equal(t, []string{"seed"}, srv.Commands())
+}
+
+func TestRconBatch(t *testing.T) {
+  srv, err := rcontest.NewServer("secret")
+  if !assert.NoError(t, err) { return }
+  defer srv.Close()
+  srv.SetUsers("alice")
+  c, err := DialRcon(context.Background(), srv.Addr(), "secret")
+  if !assert.NoError(t, err) { return }
+  defer c.Close()
+
+  out := &bytes.Buffer{}
+  assert.NoError(t, RconCommand(c, "list", out, OutputText))
+  assert.Equal(t, "There are 1 of a max of 20 players online: alice\n", out.String())
+  assert.Error(t, RconCommand(c, "bogus", out, OutputText))
+
+  out.Reset()
+  batch := "# Nightly.\nsave-all flush\n\nlist\nquit\nlist\n"
+  assert.NoError(t, RconBatch(c, strings.NewReader(batch), out, OutputText))
+  assert.Equal(t, []string{"list", "bogus", "save-all flush", "list"}, srv.Commands())
+
+  err = RconBatch(c, strings.NewReader("list\nbogus\nlist\n"), out, OutputText)
+  if assert.Error(t, err) { assert.Contains(t, err.Error(), "Line 2") }
+  assert.Len(t, srv.Commands(), 6)
+}
+
+func TestRconCommandJSON(t *testing.T) {
+  srv, err := rcontest.NewServer("secret")
+  if !assert.NoError(t, err) { return }
+  defer srv.Close()
+  srv.SetUsers("alice", "bob")
+  srv.Respond("seed", "Seed: [-42]")
+  c, err := DialRcon(context.Background(), srv.Addr(), "secret")
+  if !assert.NoError(t, err) { return }
+  defer c.Close()
+
+  out := &bytes.Buffer{}
+  // The refusal is still written out.
+  assert.Error(t, RconBatch(c, strings.NewReader("list\nseed\nsay hi\n"), out, OutputJSON))
+  lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+  if assert.Len(t, lines, 3) {
+    assert.JSONEq(t, `{"command": "list", "response": "There are 2 of a max of 20 players online: alice, bob",
+      "result": {"online": 2, "max": 20, "players": [{"name": "alice"}, {"name": "bob"}]}}`, lines[0])
+    assert.JSONEq(t, `{"command": "seed", "response": "Seed: [-42]", "result": {"seed": -42}}`, lines[1])
+    assert.Contains(t, lines[2], `"error":"Server refused`)
+  }
+}
@@ -0,0 +1,278 @@
+package lib
+
+import(
+  "bufio"
+  "bytes"
+  "context"
+  "encoding/binary"
+  "fmt"
+  "io"
+  "net"
+  "sync"
+  "time"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+// A native RCON client (https://wiki.vg/RCON).
+//
+// Packets are: int32 length, int32 request id, int32 type, a null terminated
+// body and one more null, all little-endian. The server splits long responses
+// over several packets with nothing to mark the last, so after each command
+// we send a second, bogus, packet. The server answers requests in order,
+// so once we see the answer to the bogus packet we have all of the real one.
+const(
+  rconTypeResponse = 0
+  rconTypeCommand = 2
+  rconTypeAuthResponse = 2
+  rconTypeAuth = 3
+
+  rconHeaderSize = 8 // id and type.
+  rconPadding = 2 // The two nulls.
+  rconMaxCommand = 1446
+  // Servers split responses every 4096 characters, not bytes, and a
+  // character can take up to 3 bytes once it's UTF-8.
+  rconMaxPacket = 3 * 4096 + rconHeaderSize + rconPadding
+  rconAuthFailed = -1
+
+  DefaultRconTimeout = 10 * time.Second
+)
+
+type rconPacket struct {
+  id int32
+  typ int32
+  body string
+}
+
+// Anything we can send RCON commands over.
+type RconSender interface {
+  Send(cmd string) (string, error)
+}
+
+// A connection to a server's RCON port. It's safe to use from more
+// than one goroutine, commands are sent one at a time.
+// Once a command fails on the connection, e.g. it timed out, the
+// connection is closed and the client has to be reconnected or replaced.
+type RconClient struct {
+  // How long a command has to answer, 0 for no limit beyond the context's.
+  Timeout time.Duration
+
+  addr string
+  password string
+  closed bool
+  mu sync.Mutex
+  conn net.Conn
+  r *bufio.Reader
+  nextId int32
+  err error
+}
+
+// Connect and log in, in the manner of mclib.NewRcon.
+func NewRconClient(ip string, port mclib.Port, password string) (*RconClient, error) {
+  ctx, cancel := context.WithTimeout(context.Background(), DefaultRconTimeout)
+  defer cancel()
+  return DialRcon(ctx, net.JoinHostPort(ip, port.String()), password)
+}
+
+// Connect to addr (host:port) and log in, giving up when ctx is done.
+func DialRcon(ctx context.Context, addr, password string) (*RconClient, error) {
+  var d net.Dialer
+  conn, err := d.DialContext(ctx, "tcp", addr)
+  if err != nil { return nil, err }
+  c := &RconClient{Timeout: DefaultRconTimeout, addr: addr, password: password, conn: conn, r: bufio.NewReader(conn)}
+  if err = c.auth(ctx, password); err != nil {
+    conn.Close()
+    return nil, err
+  }
+  return c, nil
+}
+
+func (c *RconClient) Addr() (string) { return c.addr }
+
+func (c *RconClient) Close() (error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  c.closed = true
+  if c.err == nil { c.err = fmt.Errorf("RCON connection to %s is closed.", c.addr) }
+  return c.conn.Close()
+}
+
+// True once a command has failed and the connection needs Reconnect.
+func (c *RconClient) Broken() (bool) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  return c.err != nil && !c.closed
+}
+
+// Replace a broken connection with a new one, logging in again.
+func (c *RconClient) Reconnect(ctx context.Context) (error) {
+  nc, err := DialRcon(ctx, c.addr, c.password)
+  if err != nil { return err }
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if c.closed {
+    nc.conn.Close()
+    return c.err
+  }
+  c.conn.Close()
+  c.conn, c.r, c.nextId, c.err = nc.conn, nc.r, nc.nextId, nil
+  return nil
+}
+
+func (c *RconClient) auth(ctx context.Context, password string) (error) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  stop := c.watch(ctx)
+  defer stop()
+  id := c.newId()
+  if err := c.write(rconPacket{id: id, typ: rconTypeAuth, body: password}); err != nil { return c.fail(ctx, err) }
+  for {
+    p, err := c.read()
+    if err != nil { return c.fail(ctx, err) }
+    // Some servers send an empty response first.
+    if p.typ != rconTypeAuthResponse { continue }
+    if p.id == rconAuthFailed { return fmt.Errorf("RCON login to %s failed: wrong password.", c.addr) }
+    if p.id != id { return fmt.Errorf("RCON login to %s failed: unexpected request id %d.", c.addr, p.id) }
+    return nil
+  }
+}
+
+// Send a command with the client's Timeout.
+func (c *RconClient) Send(cmd string) (string, error) {
+  return c.SendContext(context.Background(), cmd)
+}
+
+// Send a command and wait, until ctx is done or the client's Timeout,
+// for all of the response.
+func (c *RconClient) SendContext(ctx context.Context, cmd string) (string, error) {
+  return c.SendTimeout(ctx, cmd, c.Timeout)
+}
+
+// As SendContext, but the command has timeout (0 for no limit) rather
+// than the client's Timeout, for the ones known to be slow.
+func (c *RconClient) SendTimeout(ctx context.Context, cmd string, timeout time.Duration) (string, error) {
+  if len(cmd) > rconMaxCommand { return "", fmt.Errorf("RCON command is too long: %d bytes, the most is %d.", len(cmd), rconMaxCommand) }
+  if timeout > 0 {
+    var cancel context.CancelFunc
+    ctx, cancel = context.WithTimeout(ctx, timeout)
+    defer cancel()
+  }
+
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  if c.err != nil { return "", c.err }
+  stop := c.watch(ctx)
+  defer stop()
+
+  id, endId := c.newId(), c.newId()
+  if err := c.write(rconPacket{id: id, typ: rconTypeCommand, body: cmd}); err != nil { return "", c.fail(ctx, err) }
+  if err := c.write(rconPacket{id: endId, typ: rconTypeResponse}); err != nil { return "", c.fail(ctx, err) }
+
+  var resp bytes.Buffer
+  for {
+    p, err := c.read()
+    if err != nil { return "", c.fail(ctx, err) }
+    switch p.id {
+    case id:
+      resp.WriteString(p.body)
+    case endId:
+      // Source servers answer the terminator twice, the second time
+      // with a body of 0x00000001, which may or may not still be on its way.
+      // It has a stale id by the time we read it, and is skipped then.
+      return resp.String(), nil
+    }
+    // Anything else is left over from an earlier command.
+  }
+}
+
+// From the list command.
+func (c *RconClient) NumberOfUsers() (int, error) {
+  resp, err := c.Send("list")
+  if err != nil { return 0, err }
+  pl, err := ParseList(formatRconResp(resp))
+  if err != nil { return 0, err }
+  return pl.Online, nil
+}
+
+// Unblock reads and writes when ctx is done. Call the returned func when done.
+func (c *RconClient) watch(ctx context.Context) (stop func()) {
+  if d, ok := ctx.Deadline(); ok { c.conn.SetDeadline(d) } else { c.conn.SetDeadline(time.Time{}) }
+  done, finished := make(chan struct{}), make(chan struct{})
+  go func() {
+    defer close(finished)
+    select {
+    case <- ctx.Done():
+      c.conn.SetDeadline(time.Now())
+    case <- done:
+    }
+  }()
+  // Don't let a late deadline land on the next command.
+  return func() {
+    close(done)
+    <-finished
+  }
+}
+
+// The connection is no good once a command has failed part way.
+func (c *RconClient) fail(ctx context.Context, err error) (error) {
+  if ctxErr := ctx.Err(); ctxErr != nil { err = fmt.Errorf("RCON command to %s gave up: %s", c.addr, ctxErr) }
+  c.err = fmt.Errorf("RCON connection to %s is broken: %s", c.addr, err)
+  c.conn.Close()
+  return err
+}
+
+func (c *RconClient) newId() (int32) {
+  c.nextId++
+  if c.nextId <= 0 { c.nextId = 1 }
+  return c.nextId
+}
+
+func (c *RconClient) write(p rconPacket) (error) {
+  _, err := c.conn.Write(encodeRconPacket(p))
+  return err
+}
+
+func (c *RconClient) read() (rconPacket, error) {
+  return readRconPacket(c.r)
+}
+
+func encodeRconPacket(p rconPacket) ([]byte) {
+  size := rconHeaderSize + len(p.body) + rconPadding
+  b := make([]byte, 4 + size)
+  binary.LittleEndian.PutUint32(b[0:], uint32(size))
+  binary.LittleEndian.PutUint32(b[4:], uint32(p.id))
+  binary.LittleEndian.PutUint32(b[8:], uint32(p.typ))
+  copy(b[12:], p.body)
+  return b
+}
+
+func readRconPacket(r io.Reader) (p rconPacket, err error) {
+  var size int32
+  if err = binary.Read(r, binary.LittleEndian, &size); err != nil { return p, err }
+  if size < rconHeaderSize + rconPadding || size > rconMaxPacket {
+    return p, fmt.Errorf("Bad RCON packet length %d", size)
+  }
+  b := make([]byte, size)
+  if _, err = io.ReadFull(r, b); err != nil { return p, err }
+  p.id = int32(binary.LittleEndian.Uint32(b[0:]))
+  p.typ = int32(binary.LittleEndian.Uint32(b[4:]))
+  p.body = string(bytes.TrimRight(b[rconHeaderSize:], "\x00"))
+  return p, nil
+}
+
+// Send with ctx if rc can, otherwise without.
+func sendContext(ctx context.Context, rc RconSender, cmd string) (string, error) {
+  if cs, ok := rc.(interface{ SendContext(context.Context, string) (string, error) }); ok {
+    return cs.SendContext(ctx, cmd)
+  }
+  return rc.Send(cmd)
+}
+
+// Send with ctx and timeout if rc can, otherwise as sendContext.
+func sendTimeout(ctx context.Context, rc RconSender, cmd string, timeout time.Duration) (string, error) {
+  if ts, ok := rc.(interface{ SendTimeout(context.Context, string, time.Duration) (string, error) }); ok {
+    return ts.SendTimeout(ctx, cmd, timeout)
+  }
+  return sendContext(ctx, rc, cmd)
+}
@@ -0,0 +1,60 @@
+package lib
+
+import (
+  "context"
+  "strings"
+  "testing"
+  "time"
+  "craft-config/lib/rcontest"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestRconClient(t *testing.T) {
+  srv, err := rcontest.NewServer("secret")
+  if !assert.NoError(t, err) { return }
+  defer srv.Close()
+  long := strings.Repeat("a", 4096) + strings.Repeat("b", 4096) + "c"
+  srv.Respond("help", long)
+  // Colour codes and names that aren't ASCII take more than a byte each.
+  wide := strings.Repeat("§aé", 3000)
+  srv.Respond("banlist", wide)
+  srv.SetUsers("alice", "bob")
+  srv.Handle(func(cmd string) (string, bool) {
+    if cmd != "hang" { return "", false }
+    time.Sleep(500 * time.Millisecond)
+    return "", true
+  })
+
+  _, err = DialRcon(context.Background(), srv.Addr(), "wrong")
+  assert.Error(t, err)
+
+  c, err := DialRcon(context.Background(), srv.Addr(), "secret")
+  if !assert.NoError(t, err) { return }
+  defer c.Close()
+
+  // Comes back in three packets.
+  resp, err := c.Send("help")
+  assert.NoError(t, err)
+  assert.Equal(t, long, resp)
+
+  // Two packets, the first 4096 characters but over 4096 bytes.
+  resp, err = c.Send("banlist")
+  assert.NoError(t, err)
+  assert.Equal(t, wide, resp)
+
+  users, err := c.NumberOfUsers()
+  assert.NoError(t, err)
+  assert.Equal(t, 2, users)
+
+  ctx, cancel := context.WithTimeout(context.Background(), 100 * time.Millisecond)
+  defer cancel()
+  start := time.Now()
+  _, err = c.SendContext(ctx, "hang")
+  assert.Error(t, err)
+  assert.True(t, time.Since(start) < 400 * time.Millisecond)
+
+  // The connection is done for once a command fails.
+  _, err = c.Send("list")
+  assert.Error(t, err)
+  assert.Equal(t, []string{"help", "banlist", "list", "hang"}, srv.Commands())
+}
@@ -0,0 +1,265 @@
+// A fake Minecraft RCON server for tests.
+//
+//   srv, err := rcontest.NewServer("password")
+//   defer srv.Close()
+//   srv.SetUsers("alice", "bob")
+//   srv.Respond("seed", "Seed: [1234]")
+//   ... connect to srv.Host():srv.Port() ...
+//   srv.Commands() // What it was sent.
+//
+// Like a real server it splits responses longer than 4096 characters over
+// several packets, which with multi-byte characters can be longer than
+// 4096 bytes, and answers packets of an unknown type with
+// "Unknown request <type>".
+package rcontest
+
+import(
+  "bufio"
+  "bytes"
+  "encoding/binary"
+  "fmt"
+  "io"
+  "net"
+  "strconv"
+  "strings"
+  "sync"
+)
+
+const(
+  typeResponse = 0
+  typeCommand = 2
+  typeAuthResponse = 2
+  typeAuth = 3
+
+  maxBody = 4096 // Characters.
+  maxPacket = 1460
+  authFailed = -1
+)
+
+// Answers a command, ok is false to leave it to the next handler.
+type HandlerFunc func(cmd string) (resp string, ok bool)
+
+type Server struct {
+  Password string
+
+  ln net.Listener
+  mu sync.Mutex
+  commands []string
+  responses map[string]string
+  handlers []HandlerFunc
+  users []string
+  maxUsers int
+  legacy bool
+  conns map[net.Conn]bool
+  logins int
+  wg sync.WaitGroup
+}
+
+// Start a server on a loopback port.
+func NewServer(password string) (*Server, error) {
+  ln, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil { return nil, err }
+  s := &Server{
+    Password: password,
+    ln: ln,
+    responses: make(map[string]string),
+    maxUsers: 20,
+    conns: make(map[net.Conn]bool),
+  }
+  s.wg.Add(1)
+  go s.serve()
+  return s, nil
+}
+
+// host:port
+func (s *Server) Addr() (string) { return s.ln.Addr().String() }
+
+func (s *Server) Host() (string) {
+  host, _, _ := net.SplitHostPort(s.Addr())
+  return host
+}
+
+func (s *Server) Port() (int) {
+  _, port, _ := net.SplitHostPort(s.Addr())
+  p, _ := strconv.Atoi(port)
+  return p
+}
+
+// Stop listening and drop every connection.
+func (s *Server) Close() (error) {
+  err := s.ln.Close()
+  s.mu.Lock()
+  for c := range s.conns { c.Close() }
+  s.mu.Unlock()
+  s.wg.Wait()
+  return err
+}
+
+// Drop the connections but keep listening, as a restarting server might.
+func (s *Server) Disconnect() {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  for c := range s.conns { c.Close() }
+}
+
+// Answer cmd, exactly as sent, with resp.
+func (s *Server) Respond(cmd, resp string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.responses[cmd] = resp
+}
+
+// Handlers are tried, latest first, before the scripted responses.
+// They run on the connection's goroutine, so one may block to hold up a response.
+func (s *Server) Handle(h HandlerFunc) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.handlers = append(s.handlers, h)
+}
+
+// Who list says is online.
+func (s *Server) SetUsers(names ...string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.users = names
+}
+
+// Answer list and saving as 1.12 and earlier servers do.
+func (s *Server) SetLegacy(legacy bool) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.legacy = legacy
+}
+
+// Every command received, in order, including ones that were refused.
+func (s *Server) Commands() ([]string) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return append([]string{}, s.commands...)
+}
+
+// Successful logins.
+func (s *Server) Logins() (int) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return s.logins
+}
+
+func (s *Server) serve() {
+  defer s.wg.Done()
+  for {
+    conn, err := s.ln.Accept()
+    if err != nil { return }
+    s.mu.Lock()
+    s.conns[conn] = true
+    s.mu.Unlock()
+    s.wg.Add(1)
+    go func() {
+      defer s.wg.Done()
+      s.handleConn(conn)
+      s.mu.Lock()
+      delete(s.conns, conn)
+      s.mu.Unlock()
+      conn.Close()
+    }()
+  }
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+  r := bufio.NewReader(conn)
+  authed := false
+  for {
+    id, typ, body, err := readPacket(r)
+    if err != nil { return }
+    switch {
+    case typ == typeAuth:
+      if body != s.Password {
+        writePacket(conn, authFailed, typeAuthResponse, "")
+        continue
+      }
+      authed = true
+      s.mu.Lock()
+      s.logins++
+      s.mu.Unlock()
+      writePacket(conn, id, typeAuthResponse, "")
+    case !authed:
+      // Real servers drop the connection.
+      return
+    case typ == typeCommand:
+      s.mu.Lock()
+      s.commands = append(s.commands, body)
+      s.mu.Unlock()
+      resp := s.respond(body)
+      // Long responses come in pieces of so many characters.
+      runes := []rune(resp)
+      for {
+        n := len(runes)
+        if n > maxBody { n = maxBody }
+        if err = writePacket(conn, id, typeResponse, string(runes[:n])); err != nil { return }
+        runes = runes[n:]
+        if len(runes) == 0 { break }
+      }
+    default:
+      writePacket(conn, id, typeResponse, fmt.Sprintf("Unknown request %x", typ))
+    }
+  }
+}
+
+func (s *Server) respond(cmd string) (string) {
+  s.mu.Lock()
+  handlers := append([]HandlerFunc{}, s.handlers...)
+  resp, scripted := s.responses[cmd]
+  users := append([]string{}, s.users...)
+  maxUsers := s.maxUsers
+  legacy := s.legacy
+  s.mu.Unlock()
+
+  for i := len(handlers) - 1; i >= 0; i-- {
+    if resp, ok := handlers[i](cmd); ok { return resp }
+  }
+  if scripted { return resp }
+  if legacy {
+    switch cmd {
+    case "list":
+      return fmt.Sprintf("There are %d/%d players online:%s", len(users), maxUsers, strings.Join(users, ", "))
+    case "save-off":
+      return "Turned off world auto-saving"
+    case "save-on":
+      return "Turned on world auto-saving"
+    case "save-all", "save-all flush":
+      return "Saving...Saved the world"
+    }
+  }
+  switch cmd {
+  case "list":
+    return fmt.Sprintf("There are %d of a max of %d players online: %s", len(users), maxUsers, strings.Join(users, ", "))
+  case "save-off":
+    return "Automatic saving is now disabled"
+  case "save-on":
+    return "Automatic saving is now enabled"
+  case "save-all", "save-all flush":
+    return "Saving the game (this may take a moment!)Saved the game"
+  }
+  return fmt.Sprintf("Unknown or incomplete command, see below for error%s<--[HERE]", cmd)
+}
+
+func writePacket(w io.Writer, id, typ int32, body string) (error) {
+  b := make([]byte, 14 + len(body))
+  binary.LittleEndian.PutUint32(b[0:], uint32(10 + len(body)))
+  binary.LittleEndian.PutUint32(b[4:], uint32(id))
+  binary.LittleEndian.PutUint32(b[8:], uint32(typ))
+  copy(b[12:], body)
+  _, err := w.Write(b)
+  return err
+}
+
+func readPacket(r io.Reader) (id, typ int32, body string, err error) {
+  var size int32
+  if err = binary.Read(r, binary.LittleEndian, &size); err != nil { return 0, 0, "", err }
+  if size < 10 || size > maxPacket + 10 { return 0, 0, "", fmt.Errorf("Bad packet length %d", size) }
+  b := make([]byte, size)
+  if _, err = io.ReadFull(r, b); err != nil { return 0, 0, "", err }
+  id = int32(binary.LittleEndian.Uint32(b[0:]))
+  typ = int32(binary.LittleEndian.Uint32(b[4:]))
+  return id, typ, string(bytes.TrimRight(b[8:], "\x00")), nil
+}
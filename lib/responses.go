@@ -0,0 +1,191 @@
+package lib
+
+import(
+  "fmt"
+  "regexp"
+  "strconv"
+  "strings"
+)
+
+// Typed versions of the answers to common commands, parsed from the
+// cleaned (colour codes removed) response text. Vanilla wording has
+// changed over the years, the older forms are understood too.
+
+type Player struct {
+  Name string `json:"name"`
+  UUID string `json:"uuid,omitempty"` // Only from list uuids.
+}
+
+// list, list uuids
+type PlayerList struct {
+  Online int `json:"online"`
+  Max int `json:"max"`
+  Players []Player `json:"players"`
+}
+
+// whitelist list
+type Whitelist struct {
+  Players []string `json:"players"`
+}
+
+type Ban struct {
+  Target string `json:"target"`
+  Source string `json:"source"`
+  Reason string `json:"reason"`
+}
+
+// banlist
+type BanList struct {
+  Bans []Ban `json:"bans"`
+}
+
+// seed
+type Seed struct {
+  Seed int64 `json:"seed"`
+}
+
+// time query daytime|gametime|day
+type TimeQuery struct {
+  Query string `json:"query"`
+  Time int64 `json:"time"`
+}
+
+// Spigot and Paper's tps.
+type TPS struct {
+  OneMinute float64 `json:"oneMinute"`
+  FiveMinutes float64 `json:"fiveMinutes"`
+  FifteenMinutes float64 `json:"fifteenMinutes"`
+}
+
+var(
+  listRE = regexp.MustCompile(`(?s)There are (\d+)(?: of a max of |/)(\d+) players online:\s*(.*)`)
+  playerUUIDRE = regexp.MustCompile(`^(\S+) \(([0-9a-fA-F-]{36})\)$`)
+  whitelistRE = regexp.MustCompile(`(?s)There (?:are|is) (\d+) (?:\(out of \d+ seen\) )?whitelisted players?:\s*(.*)`)
+  banlistRE = regexp.MustCompile(`(?s)There (?:are|is) (\d+) bans?(?:\(s\))?:\s*(.*)`)
+  // Players or IP addresses.
+  banRE = regexp.MustCompile(`(\d{1,3}(?:\.\d{1,3}){3}|[A-Za-z0-9_]{1,16}) was banned by (\S+): `)
+  // 1.12 and earlier: There are 2 total banned players: alice, bob
+  oldBanlistRE = regexp.MustCompile(`(?s)There (?:are|is) (\d+) total banned (?:players|IP addresses):\s*(.*)`)
+  // 1.12 and earlier leave out the brackets.
+  seedRE = regexp.MustCompile(`Seed: \[?(-?\d+)\]?`)
+  // 1.12 and earlier: Time is 6000
+  timeRE = regexp.MustCompile(`(?:The time|Time) is (\d+)`)
+  tpsRE = regexp.MustCompile(`TPS from last 1m, 5m, 15m:\s*(.*)`)
+)
+
+// Names separated by commas, and perhaps an "and".
+func splitNames(s string) (names []string) {
+  s = strings.Replace(strings.TrimSpace(s), " and ", ", ", -1)
+  for _, n := range strings.Split(s, ",") {
+    if n = strings.TrimSpace(n); n != "" { names = append(names, n) }
+  }
+  return names
+}
+
+func ParseList(resp string) (*PlayerList, error) {
+  m := listRE.FindStringSubmatch(resp)
+  if m == nil { return nil, fmt.Errorf("Not a list response: %s", resp) }
+  pl := &PlayerList{Players: make([]Player, 0)}
+  pl.Online, _ = strconv.Atoi(m[1])
+  pl.Max, _ = strconv.Atoi(m[2])
+  for _, n := range splitNames(m[3]) {
+    p := Player{Name: n}
+    if um := playerUUIDRE.FindStringSubmatch(n); um != nil { p = Player{Name: um[1], UUID: um[2]} }
+    pl.Players = append(pl.Players, p)
+  }
+  return pl, nil
+}
+
+func ParseWhitelist(resp string) (*Whitelist, error) {
+  wl := &Whitelist{Players: make([]string, 0)}
+  if strings.Contains(resp, "There are no whitelisted players") { return wl, nil }
+  m := whitelistRE.FindStringSubmatch(resp)
+  if m == nil { return nil, fmt.Errorf("Not a whitelist response: %s", resp) }
+  if names := splitNames(m[2]); names != nil { wl.Players = names }
+  return wl, nil
+}
+
+// The bans run together without separators over RCON, so each reason
+// runs up to the next player name or address that "was banned by".
+// A reason ending in a letter or digit can't be told from the name that
+// follows it, and loses its last word to the name.
+func ParseBanlist(resp string) (*BanList, error) {
+  bl := &BanList{Bans: make([]Ban, 0)}
+  if strings.Contains(resp, "There are no bans") { return bl, nil }
+  // Just the names.
+  if m := oldBanlistRE.FindStringSubmatch(resp); m != nil {
+    for _, n := range splitNames(m[2]) { bl.Bans = append(bl.Bans, Ban{Target: n}) }
+    return bl, nil
+  }
+  m := banlistRE.FindStringSubmatch(resp)
+  if m == nil { return nil, fmt.Errorf("Not a banlist response: %s", resp) }
+  body := m[2]
+  locs := banRE.FindAllStringSubmatchIndex(body, -1)
+  for i, loc := range locs {
+    end := len(body)
+    if i + 1 < len(locs) { end = locs[i+1][0] }
+    bl.Bans = append(bl.Bans, Ban{
+      Target: body[loc[2]:loc[3]],
+      Source: body[loc[4]:loc[5]],
+      Reason: strings.TrimSpace(body[loc[1]:end]),
+    })
+  }
+  return bl, nil
+}
+
+func ParseSeed(resp string) (*Seed, error) {
+  m := seedRE.FindStringSubmatch(resp)
+  if m == nil { return nil, fmt.Errorf("Not a seed response: %s", resp) }
+  seed, err := strconv.ParseInt(m[1], 10, 64)
+  if err != nil { return nil, err }
+  return &Seed{Seed: seed}, nil
+}
+
+// query is what was asked for: daytime, gametime or day.
+func ParseTimeQuery(query, resp string) (*TimeQuery, error) {
+  m := timeRE.FindStringSubmatch(resp)
+  if m == nil { return nil, fmt.Errorf("Not a time query response: %s", resp) }
+  t, err := strconv.ParseInt(m[1], 10, 64)
+  if err != nil { return nil, err }
+  return &TimeQuery{Query: query, Time: t}, nil
+}
+
+// Paper marks rates it's capped with a *, e.g. *20.0.
+func ParseTPS(resp string) (*TPS, error) {
+  m := tpsRE.FindStringSubmatch(resp)
+  if m == nil { return nil, fmt.Errorf("Not a tps response: %s", resp) }
+  parts := strings.Split(m[1], ",")
+  if len(parts) != 3 { return nil, fmt.Errorf("Expected 3 rates in tps response: %s", resp) }
+  rates := make([]float64, 3)
+  for i, p := range parts {
+    r, err := strconv.ParseFloat(strings.TrimLeft(strings.TrimSpace(p), "*"), 64)
+    if err != nil { return nil, fmt.Errorf("Bad rate \"%s\" in tps response", p) }
+    rates[i] = r
+  }
+  return &TPS{OneMinute: rates[0], FiveMinutes: rates[1], FifteenMinutes: rates[2]}, nil
+}
+
+// Parse the response to cmd if we know how. ok is false for commands
+// we don't have a parser for.
+func ParseResponse(cmd, resp string) (parsed interface{}, ok bool, err error) {
+  fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(cmd), "/"))
+  if len(fields) == 0 { return nil, false, nil }
+  resp = formatRconResp(resp)
+  switch {
+  case fields[0] == "list":
+    parsed, err = ParseList(resp)
+  case fields[0] == "whitelist" && len(fields) == 2 && fields[1] == "list":
+    parsed, err = ParseWhitelist(resp)
+  case fields[0] == "banlist":
+    parsed, err = ParseBanlist(resp)
+  case fields[0] == "seed":
+    parsed, err = ParseSeed(resp)
+  case fields[0] == "time" && len(fields) == 3 && fields[1] == "query":
+    parsed, err = ParseTimeQuery(fields[2], resp)
+  case fields[0] == "tps":
+    parsed, err = ParseTPS(resp)
+  default:
+    return nil, false, nil
+  }
+  return parsed, true, err
+}
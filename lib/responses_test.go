@@ -0,0 +1,73 @@
+package lib
+
+import (
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestParseList(t *testing.T) {
+  pl, err := ParseList("There are 2 of a max of 20 players online: alice, bob")
+  assert.NoError(t, err)
+  assert.Equal(t, &PlayerList{Online: 2, Max: 20, Players: []Player{{Name: "alice"}, {Name: "bob"}}}, pl)
+
+  pl, err = ParseList("There are 1 of a max of 10 players online: alice (069a79f4-44e9-4726-a5be-fca90e38aaf5)")
+  assert.NoError(t, err)
+  assert.Equal(t, Player{Name: "alice", UUID: "069a79f4-44e9-4726-a5be-fca90e38aaf5"}, pl.Players[0])
+
+  // 1.12 and earlier.
+  pl, err = ParseList("There are 0/20 players online:")
+  assert.NoError(t, err)
+  assert.Equal(t, 0, pl.Online)
+  assert.Len(t, pl.Players, 0)
+
+  _, err = ParseList("Unknown command")
+  assert.Error(t, err)
+}
+
+func TestParseOtherResponses(t *testing.T) {
+  wl, err := ParseWhitelist("There are 3 whitelisted players: alice, bob and carol")
+  assert.NoError(t, err)
+  assert.Equal(t, []string{"alice", "bob", "carol"}, wl.Players)
+  wl, err = ParseWhitelist("There are no whitelisted players")
+  assert.NoError(t, err)
+  assert.Len(t, wl.Players, 0)
+
+  bl, err := ParseBanlist("There are 2 ban(s):alice was banned by Server: Griefing.bob was banned by carol: Spam: lots of it")
+  assert.NoError(t, err)
+  assert.Equal(t, []Ban{
+    {Target: "alice", Source: "Server", Reason: "Griefing."},
+    {Target: "bob", Source: "carol", Reason: "Spam: lots of it"},
+  }, bl.Bans)
+
+  seed, err := ParseSeed("Seed: [-4172144997902289642]")
+  assert.NoError(t, err)
+  assert.Equal(t, int64(-4172144997902289642), seed.Seed)
+
+  parsed, ok, err := ParseResponse("time query daytime", "The time is 6000")
+  assert.True(t, ok)
+  assert.NoError(t, err)
+  assert.Equal(t, &TimeQuery{Query: "daytime", Time: 6000}, parsed)
+
+  // 1.12 and earlier.
+  seed, err = ParseSeed("Seed: 1234")
+  assert.NoError(t, err)
+  assert.Equal(t, int64(1234), seed.Seed)
+  parsed, ok, err = ParseResponse("time query daytime", "Time is 6000")
+  assert.True(t, ok)
+  assert.NoError(t, err)
+  assert.Equal(t, &TimeQuery{Query: "daytime", Time: 6000}, parsed)
+  bl, err = ParseBanlist("There are 2 total banned players:alice, bob")
+  assert.NoError(t, err)
+  assert.Equal(t, []Ban{{Target: "alice"}, {Target: "bob"}}, bl.Bans)
+  bl, err = ParseBanlist("There are 0 total banned players:")
+  assert.NoError(t, err)
+  assert.Len(t, bl.Bans, 0)
+
+  parsed, ok, err = ParseResponse("tps", "§6TPS from last 1m, 5m, 15m: §a*20.0, §a19.97, §e17.5")
+  assert.True(t, ok)
+  assert.NoError(t, err)
+  assert.Equal(t, &TPS{OneMinute: 20, FiveMinutes: 19.97, FifteenMinutes: 17.5}, parsed)
+
+  _, ok, _ = ParseResponse("say hi", "")
+  assert.False(t, ok)
+}
@@ -0,0 +1,211 @@
+package lib
+
+import(
+  "bufio"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net"
+  "net/url"
+  "os"
+  "path/filepath"
+  "strings"
+  "syscall"
+  "time"
+  "github.com/aws/aws-sdk-go/aws/session"
+  "github.com/Sirupsen/logrus"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+const(
+  defaultLevelName = "world"
+  sessionLockFile = "session.lock"
+  rconDialTimeout = 2 * time.Second
+)
+
+// Result of swapping a restored archive into a server directory.
+type RestoreResult struct {
+  URI string
+  ServerDirectory string
+  PreviousDirectory string // Empty if there was nothing to set aside.
+  Files int
+}
+
+// Parse an archive URI of the form s3://bucket/key.
+func ParseS3URI(uri string) (bucket, key string, err error) {
+  u, err := url.Parse(uri)
+  if err != nil { return bucket, key, fmt.Errorf("Bad archive URI \"%s\": %s", uri, err) }
+  if u.Scheme != "s3" {
+    return bucket, key, fmt.Errorf("Archive URI must be of the form s3://bucket/key: %s", uri)
+  }
+  bucket = u.Host
+  key = strings.TrimPrefix(u.Path, "/")
+  if bucket == "" || key == "" {
+    return bucket, key, fmt.Errorf("Archive URI must include both a bucket and a key: %s", uri)
+  }
+  return bucket, key, nil
+}
+
+// Reads level-name out of server.properties in the server directory.
+// Defaults to world if we can't find it.
+func LevelName(serverDir string) (string) {
+  file, err := os.Open(filepath.Join(serverDir, "server.properties"))
+  if err != nil { return defaultLevelName }
+  defer file.Close()
+  scanner := bufio.NewScanner(file)
+  for scanner.Scan() {
+    line := strings.TrimSpace(scanner.Text())
+    if strings.HasPrefix(line, "#") { continue }
+    kv := strings.SplitN(line, "=", 2)
+    if len(kv) == 2 && strings.TrimSpace(kv[0]) == "level-name" {
+      if name := strings.TrimSpace(kv[1]); name != "" { return name }
+    }
+  }
+  return defaultLevelName
+}
+
+// A server is running if we can reach its RCON port, or if someone
+// holds the lock on the world's session.lock.
+// The reason is returned to tell the user why we think so.
+func ServerIsRunning(serverIp string, rconPort mclib.Port, serverDir string) (running bool, reason string) {
+  addr := net.JoinHostPort(serverIp, rconPort.String())
+  if conn, err := net.DialTimeout("tcp", addr, rconDialTimeout); err == nil {
+    conn.Close()
+    return true, fmt.Sprintf("RCON is reachable at %s", addr)
+  }
+
+  lockFile := filepath.Join(serverDir, LevelName(serverDir), sessionLockFile)
+  if sessionLockHeld(lockFile) {
+    return true, fmt.Sprintf("%s is locked", lockFile)
+  }
+  return false, ""
+}
+
+// Minecraft takes its lock on session.lock through java's FileChannel.tryLock()
+// which is an fcntl lock, so ask if anyone has a conflicting lock on it.
+func sessionLockHeld(lockFile string) (bool) {
+  file, err := os.OpenFile(lockFile, os.O_RDWR, 0)
+  if err != nil { return false }
+  defer file.Close()
+  lk := syscall.Flock_t{
+    Type: syscall.F_WRLCK,
+    Whence: int16(io.SeekStart),
+  }
+  if err := syscall.FcntlFlock(file.Fd(), syscall.F_GETLK, &lk); err != nil { return false }
+  return lk.Type != syscall.F_UNLCK
+}
+
+// Download an archive (from any store) and swap it in to the server directory.
+// The server must not be running. The archive is unpacked into a
+// staging directory next to the server directory, then each of the
+// top level files and directories it holds is moved into place, with
+// the one it replaces moved aside to a previous directory. Anything
+// the archive doesn't hold, like server.properties when restoring a
+// WorldSnapshot, is left alone. If the swap fails what was moved is
+// moved back. Encrypted archives are decrypted with enc.
+func RestoreArchive(uri string, serverIp string, rconPort mclib.Port, serverDir string, sess *session.Session, enc *Encryption) (result *RestoreResult, err error) {
+  f := logrus.Fields{"uri": uri, "serverDir": serverDir, "operation": "Restore"}
+
+  if running, reason := ServerIsRunning(serverIp, rconPort, serverDir); running {
+    return nil, fmt.Errorf("Server appears to be running, stop it before restoring: %s", reason)
+  }
+
+  serverDir, err = filepath.Abs(filepath.Clean(serverDir))
+  if err != nil { return nil, err }
+  stamp := time.Now().UTC().Format("20060102T150405")
+
+  store, key, err := OpenArchiveURI(uri, sess)
+  if err != nil { return nil, err }
+
+  stagingDir := fmt.Sprintf("%s.restore-%s", serverDir, stamp)
+  f["stagingDir"] = stagingDir
+  log.Debug(f, "Unpacking archive to staging directory.")
+  files, err := unpackArchive(store, key, stagingDir, enc)
+  defer os.RemoveAll(stagingDir)
+  if err != nil { return nil, err }
+
+  entries, err := ioutil.ReadDir(stagingDir)
+  if err != nil { return nil, err }
+  names := make([]string, len(entries))
+  for i, e := range entries { names[i] = e.Name() }
+  if err = os.MkdirAll(serverDir, 0755); err != nil { return nil, err }
+
+  result = &RestoreResult{
+    URI: uri,
+    ServerDirectory: serverDir,
+    Files: files,
+  }
+  previousDir := fmt.Sprintf("%s.previous-%s", serverDir, stamp)
+  f["previousDir"] = previousDir
+  setAside, err := swapEntries(stagingDir, serverDir, previousDir, names, os.Rename)
+  if err != nil {
+    log.Error(f, "Failed to move restored archive into place, rolled back.", err)
+    return nil, fmt.Errorf("Couldn't move the restored archive into place: %s", err)
+  }
+  if setAside { result.PreviousDirectory = previousDir }
+
+  f["files"] = files
+  log.Info(f, "Restored archive.")
+  return result, nil
+}
+
+// Move each of names from stagingDir into serverDir, first moving what's
+// there already into previousDir. setAside is true if anything was.
+// If a move fails the ones already made are undone.
+func swapEntries(stagingDir, serverDir, previousDir string, names []string, rename func(from, to string) (error)) (setAside bool, err error) {
+  type move struct{ from, to string }
+  var done []move
+  do := func(from, to string) (error) {
+    if err := rename(from, to); err != nil { return err }
+    done = append(done, move{from, to})
+    return nil
+  }
+
+  for _, name := range names {
+    current := filepath.Join(serverDir, name)
+    if _, err = os.Lstat(current); err == nil {
+      if !setAside {
+        if err = os.Mkdir(previousDir, 0755); err != nil { break }
+        setAside = true
+      }
+      if err = do(current, filepath.Join(previousDir, name)); err != nil { break }
+    } else if !os.IsNotExist(err) {
+      break
+    }
+    if err = do(filepath.Join(stagingDir, name), current); err != nil { break }
+  }
+  if err == nil { return setAside, nil }
+
+  f := logrus.Fields{"serverDir": serverDir, "previousDir": previousDir, "operation": "Restore"}
+  for i := len(done) - 1; i >= 0; i-- {
+    if rerr := rename(done[i].to, done[i].from); rerr != nil {
+      f["path"] = done[i].from
+      log.Error(f, "Rollback failed, previous server contents remain in previousDir.", rerr)
+    }
+  }
+  // Only goes if the rollback emptied it.
+  if setAside { os.Remove(previousDir) }
+  return false, err
+}
+
+// Rebuild the archive's files in destDir, whatever kind of archive it is.
+func unpackArchive(store ArchiveStore, key, destDir string, enc *Encryption) (files int, err error) {
+  if IsIncrementalKey(key) { return restoreIncremental(store, key, destDir) }
+  format, _ := FormatFromKey(key)
+  rc, err := store.Get(key)
+  if err != nil { return 0, err }
+  defer rc.Close()
+  r, err := decryptIfNeeded(rc, enc)
+  if err != nil { return 0, err }
+  return extractArchive(format, r, destDir)
+}
+
+func safeJoin(base, name string) (string, error) {
+  path := filepath.Join(base, filepath.FromSlash(name))
+  if path != base && !strings.HasPrefix(path, base + string(os.PathSeparator)) {
+    return "", fmt.Errorf("Archive entry escapes the restore directory: %s", name)
+  }
+  return path, nil
+}
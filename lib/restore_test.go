@@ -0,0 +1,137 @@
+package lib
+
+import (
+  "archive/zip"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  "github.com/stretchr/testify/assert"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+func TestParseS3URI(t *testing.T) {
+  b, k, err := ParseS3URI("s3://momentlabs-test/user/server/world.zip")
+  assert.NoError(t, err)
+  assert.Equal(t, "momentlabs-test", b)
+  assert.Equal(t, "user/server/world.zip", k)
+
+  _, _, err = ParseS3URI("https://momentlabs-test/user/server/world.zip")
+  assert.Error(t, err)
+  _, _, err = ParseS3URI("s3://momentlabs-test")
+  assert.Error(t, err)
+}
+
+func TestExtractArchiveRefusesEscapes(t *testing.T) {
+  dir, err := ioutil.TempDir("", "restore-test")
+  assert.NoError(t, err)
+  defer os.RemoveAll(dir)
+
+  zipName := filepath.Join(dir, "bad.zip")
+  zf, err := os.Create(zipName)
+  assert.NoError(t, err)
+  zw := zip.NewWriter(zf)
+  w, err := zw.Create("world/level.dat")
+  assert.NoError(t, err)
+  w.Write([]byte("level"))
+  w, err = zw.Create("../outside.txt")
+  assert.NoError(t, err)
+  w.Write([]byte("nope"))
+  assert.NoError(t, zw.Close())
+  zf.Close()
+
+  zf, err = os.Open(zipName)
+  assert.NoError(t, err)
+  defer zf.Close()
+  _, err = extractArchive(FormatZip, zf, filepath.Join(dir, "staging"))
+  assert.Error(t, err)
+  _, err = os.Stat(filepath.Join(dir, "outside.txt"))
+  assert.True(t, os.IsNotExist(err))
+}
+
+func TestRestoreArchiveSwapsOnlyWhatsInIt(t *testing.T) {
+  dir, err := ioutil.TempDir("", "restore-swap")
+  assert.NoError(t, err)
+  defer os.RemoveAll(dir)
+  serverDir := filepath.Join(dir, "server")
+  write := func(name, contents string) {
+    name = filepath.Join(serverDir, name)
+    assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+    assert.NoError(t, ioutil.WriteFile(name, []byte(contents), 0644))
+  }
+  read := func(name string) (string) {
+    b, _ := ioutil.ReadFile(name)
+    return string(b)
+  }
+  write("server.properties", "level-name=world\n")
+  write("plugins/plugin.jar", "jar")
+  write("world/level.dat", "saved")
+
+  store := NewFileStore(filepath.Join(dir, "archives"))
+  s := &mclib.Server{User: "testuser", Name: "testserver", ServerDirectory: serverDir}
+  snap, err := TakeSnapshot(s, mclib.WorldSnapshot, store, SnapshotOptions{})
+  if !assert.NoError(t, err) { return }
+
+  write("world/level.dat", "changed")
+  write("world/extra.dat", "extra")
+  result, err := RestoreArchive(snap.URI, "127.0.0.1", mclib.Port(1), serverDir, nil, nil)
+  if !assert.NoError(t, err) { return }
+  assert.Equal(t, "saved", read(filepath.Join(serverDir, "world", "level.dat")))
+  _, err = os.Stat(filepath.Join(serverDir, "world", "extra.dat"))
+  assert.True(t, os.IsNotExist(err))
+  // The rest of the server is untouched.
+  assert.Equal(t, "level-name=world\n", read(filepath.Join(serverDir, "server.properties")))
+  assert.Equal(t, "jar", read(filepath.Join(serverDir, "plugins", "plugin.jar")))
+  // Only the world was set aside.
+  assert.Equal(t, "changed", read(filepath.Join(result.PreviousDirectory, "world", "level.dat")))
+  previous, _ := ioutil.ReadDir(result.PreviousDirectory)
+  assert.Len(t, previous, 1)
+  staging, _ := filepath.Glob(serverDir + ".restore-*")
+  assert.Len(t, staging, 0)
+
+  // Into an empty directory nothing is set aside.
+  result, err = RestoreArchive(snap.URI, "127.0.0.1", mclib.Port(1), filepath.Join(dir, "new"), nil, nil)
+  if assert.NoError(t, err) {
+    assert.Equal(t, "", result.PreviousDirectory)
+    assert.Equal(t, "saved", read(filepath.Join(dir, "new", "world", "level.dat")))
+  }
+}
+
+func TestSwapEntriesRollsBack(t *testing.T) {
+  dir, err := ioutil.TempDir("", "restore-rollback")
+  assert.NoError(t, err)
+  defer os.RemoveAll(dir)
+  stagingDir := filepath.Join(dir, "staging")
+  serverDir := filepath.Join(dir, "server")
+  previousDir := filepath.Join(dir, "previous")
+  for _, d := range []string{stagingDir, serverDir} {
+    assert.NoError(t, os.MkdirAll(d, 0755))
+    for _, name := range []string{"world", "world_nether"} {
+      assert.NoError(t, ioutil.WriteFile(filepath.Join(d, name), []byte(d), 0644))
+    }
+  }
+
+  // Fail each move in turn: world aside, world in, world_nether aside, world_nether in.
+  for fail := 1; fail <= 4; fail++ {
+    calls := 0
+    rename := func(from, to string) (error) {
+      calls++
+      if calls == fail { return fmt.Errorf("Rename failed.") }
+      return os.Rename(from, to)
+    }
+    setAside, err := swapEntries(stagingDir, serverDir, previousDir, []string{"world", "world_nether"}, rename)
+    assert.Error(t, err, "failing move %d", fail)
+    assert.False(t, setAside)
+    for _, d := range []string{stagingDir, serverDir} {
+      for _, name := range []string{"world", "world_nether"} {
+        b, _ := ioutil.ReadFile(filepath.Join(d, name))
+        assert.Equal(t, d, string(b), "failing move %d: %s/%s", fail, filepath.Base(d), name)
+      }
+    }
+    _, err = os.Stat(previousDir)
+    assert.True(t, os.IsNotExist(err), "failing move %d", fail)
+  }
+}
@@ -0,0 +1,139 @@
+package lib
+
+import(
+  "fmt"
+  "sort"
+  "time"
+  "github.com/Sirupsen/logrus"
+)
+
+// Grandfather-father-son retention.
+// Within each user/server/ArchiveType we keep the newest KeepLast archives,
+// plus the newest archive of each of the last Daily days, Weekly weeks
+// and Monthly months that have archives in them.
+type RetentionPolicy struct {
+  KeepLast int
+  Daily int
+  Weekly int
+  Monthly int
+}
+
+func (p RetentionPolicy) String() string {
+  return fmt.Sprintf("last=%d daily=%d weekly=%d monthly=%d", p.KeepLast, p.Daily, p.Weekly, p.Monthly)
+}
+
+// A policy that keeps nothing is almost certainly a mistake.
+func (p RetentionPolicy) Validate() (error) {
+  if p.KeepLast < 0 || p.Daily < 0 || p.Weekly < 0 || p.Monthly < 0 {
+    return fmt.Errorf("Retention counts can't be negative: %s", p)
+  }
+  if p.KeepLast + p.Daily + p.Weekly + p.Monthly == 0 {
+    return fmt.Errorf("Retention policy would remove every archive: %s", p)
+  }
+  return nil
+}
+
+type PruneDecision struct {
+  Archive ArchiveEntry
+  Keep bool
+  Reason string
+}
+
+// Decide which archives to keep and which to prune.
+// Decisions come back grouped by user/server/type, newest first within a group.
+func ApplyRetention(archives []ArchiveEntry, p RetentionPolicy) ([]PruneDecision) {
+  groups := make(map[string][]ArchiveEntry)
+  groupNames := make([]string, 0)
+  for _, a := range archives {
+    g := fmt.Sprintf("%s/%s/%s", a.UserName, a.ServerName, a.Type.String())
+    if _, ok := groups[g]; !ok { groupNames = append(groupNames, g) }
+    groups[g] = append(groups[g], a)
+  }
+  sort.Strings(groupNames)
+
+  decisions := make([]PruneDecision, 0, len(archives))
+  for _, g := range groupNames {
+    al := groups[g]
+    sort.Sort(sort.Reverse(ByLastMod(al)))
+    times := make([]time.Time, len(al))
+    for i, a := range al {
+      times[i] = a.LastMod
+    }
+    for i, reason := range p.retain(times) {
+      decisions = append(decisions, PruneDecision{Archive: al[i], Keep: reason != expired, Reason: reason})
+    }
+  }
+  return decisions
+}
+
+const expired = "expired"
+
+// Takes times sorted newest first, returns the reason
+// each one is kept, or expired.
+func (p RetentionPolicy) retain(times []time.Time) ([]string) {
+  reasons := make([]string, len(times))
+  days := make(map[string]bool)
+  weeks := make(map[string]bool)
+  months := make(map[string]bool)
+  for i, t := range times {
+    t = t.UTC()
+    day := t.Format("2006-01-02")
+    year, wk := t.ISOWeek()
+    week := fmt.Sprintf("%d-W%02d", year, wk)
+    month := t.Format("2006-01")
+    switch {
+    case i < p.KeepLast:
+      reasons[i] = "last"
+    case !days[day] && len(days) < p.Daily:
+      reasons[i] = "daily"
+    case !weeks[week] && len(weeks) < p.Weekly:
+      reasons[i] = "weekly"
+    case !months[month] && len(months) < p.Monthly:
+      reasons[i] = "monthly"
+    default:
+      reasons[i] = expired
+    }
+    // Every archive marks its own periods as covered, so the newest
+    // archive in a period is the one that counts for that period.
+    if len(days) < p.Daily { days[day] = true }
+    if len(weeks) < p.Weekly { weeks[week] = true }
+    if len(months) < p.Monthly { months[month] = true }
+  }
+  return reasons
+}
+
+// Apply the policy and delete whatever it doesn't keep from the store.
+// With dryRun nothing is deleted, the decisions are just returned.
+func PruneArchives(store ArchiveStore, archives []ArchiveEntry, p RetentionPolicy, dryRun bool) (decisions []PruneDecision, err error) {
+  if err = p.Validate(); err != nil { return nil, err }
+  decisions = ApplyRetention(archives, p)
+  if dryRun { return decisions, nil }
+
+  f := logrus.Fields{"operation": "Prune", "policy": p.String()}
+  incrementals := make(map[string][2]string)
+  for _, d := range decisions {
+    if d.Keep { continue }
+    f["archive"] = d.Archive.URI
+    if err = store.Delete(d.Archive.Key); err != nil {
+      log.Error(f, "Failed to delete archive.", err)
+      return decisions, err
+    }
+    log.Debug(f, "Deleted archive.")
+    if IsIncrementalKey(d.Archive.Key) {
+      incrementals[d.Archive.UserName + "/" + d.Archive.ServerName] = [2]string{d.Archive.UserName, d.Archive.ServerName}
+    } else if err = store.Delete(ManifestKey(d.Archive.Key)); err != nil {
+      // Older archives were published without one.
+      log.Debug(f, "No manifest deleted with archive.")
+      err = nil
+    }
+  }
+
+  // Pruned incremental snapshots may have left blobs that nothing refers to.
+  for _, us := range incrementals {
+    if _, err = CollectGarbageBlobs(store, us[0], us[1], time.Now().Add(-BlobGracePeriod)); err != nil {
+      log.Error(f, "Failed to collect unreferenced blobs.", err)
+      return decisions, err
+    }
+  }
+  return decisions, nil
+}
@@ -0,0 +1,37 @@
+package lib
+
+import (
+  "testing"
+  "time"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestRetain(t *testing.T) {
+  now := time.Date(2017, 3, 15, 12, 0, 0, 0, time.UTC)
+  // Hourly archives for ten days, newest first.
+  times := []time.Time{}
+  for h := 0; h < 240; h++ {
+    times = append(times, now.Add(-time.Duration(h) * time.Hour))
+  }
+
+  reasons := RetentionPolicy{KeepLast: 3, Daily: 5}.retain(times)
+  assert.Equal(t, len(times), len(reasons))
+  kept := map[string]int{}
+  for _, r := range reasons {
+    kept[r]++
+  }
+  assert.Equal(t, 3, kept["last"])
+  // Today is covered by the last three, the next four days each keep one.
+  assert.Equal(t, 4, kept["daily"])
+  assert.Equal(t, 0, kept["weekly"])
+  assert.Equal(t, len(times) - 7, kept[expired])
+  assert.Equal(t, "last", reasons[0])
+
+  // All ten days are in March, so only one of them survives.
+  reasons = RetentionPolicy{Monthly: 2}.retain(times)
+  assert.Equal(t, "monthly", reasons[0])
+  assert.Equal(t, expired, reasons[1])
+  assert.Equal(t, expired, reasons[len(reasons) - 1])
+
+  assert.Error(t, RetentionPolicy{}.Validate())
+}
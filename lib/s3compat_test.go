@@ -0,0 +1,158 @@
+package lib
+
+import (
+  "crypto/md5"
+  "encoding/xml"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "sync"
+  "testing"
+  "time"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/aws/credentials"
+  "github.com/aws/aws-sdk-go/aws/session"
+  "github.com/stretchr/testify/assert"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+// Just enough of a path-style S3 API to stand in for MinIO:
+// PUT, GET and DELETE on /bucket/key and ListObjectsV2 on /bucket.
+type fakeS3 struct {
+  sync.Mutex
+  objects map[string][]byte
+  modified map[string]time.Time
+}
+
+type listResult struct {
+  XMLName xml.Name `xml:"ListBucketResult"`
+  Name string
+  Prefix string
+  KeyCount int
+  IsTruncated bool
+  Contents []listContent
+}
+
+type listContent struct {
+  Key string
+  LastModified string
+  ETag string
+  Size int
+}
+
+func newFakeS3() (*fakeS3) {
+  return &fakeS3{objects: make(map[string][]byte), modified: make(map[string]time.Time)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  f.Lock()
+  defer f.Unlock()
+  parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+  bucket := parts[0]
+  if len(parts) == 1 || parts[1] == "" {
+    if r.Method != http.MethodGet { http.Error(w, "bad request", http.StatusBadRequest); return }
+    prefix := r.URL.Query().Get("prefix")
+    result := listResult{Name: bucket, Prefix: prefix}
+    keys := make([]string, 0)
+    for k := range f.objects {
+      if strings.HasPrefix(k, bucket + "/" + prefix) { keys = append(keys, k) }
+    }
+    sort.Strings(keys)
+    for _, k := range keys {
+      result.Contents = append(result.Contents, listContent{
+        Key: strings.TrimPrefix(k, bucket + "/"),
+        LastModified: f.modified[k].UTC().Format("2006-01-02T15:04:05.000Z"),
+        ETag: etag(f.objects[k]),
+        Size: len(f.objects[k]),
+      })
+    }
+    result.KeyCount = len(result.Contents)
+    w.Header().Set("Content-Type", "application/xml")
+    xml.NewEncoder(w).Encode(result)
+    return
+  }
+
+  k := bucket + "/" + parts[1]
+  switch r.Method {
+  case http.MethodPut:
+    b, _ := ioutil.ReadAll(r.Body)
+    f.objects[k] = b
+    f.modified[k] = time.Now()
+    w.Header().Set("ETag", etag(b))
+  case http.MethodGet:
+    b, ok := f.objects[k]
+    if !ok {
+      w.WriteHeader(http.StatusNotFound)
+      fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code><Message>%s</Message></Error>", k)
+      return
+    }
+    w.Header().Set("ETag", etag(b))
+    w.Write(b)
+  case http.MethodDelete:
+    delete(f.objects, k)
+    w.WriteHeader(http.StatusNoContent)
+  }
+}
+
+func etag(b []byte) (string) {
+  return fmt.Sprintf("\"%x\"", md5.Sum(b))
+}
+
+func TestS3CompatibleEndpoint(t *testing.T) {
+  fake := newFakeS3()
+  ts := httptest.NewServer(fake)
+  defer ts.Close()
+
+  SetS3Endpoint(S3Endpoint{URL: ts.URL, PathStyle: true, DisableSSL: true})
+  defer SetS3Endpoint(S3Endpoint{})
+  sess, err := session.NewSession(&aws.Config{
+    Region: aws.String("us-east-1"),
+    Credentials: credentials.NewStaticCredentials("minio", "minio123", ""),
+  })
+  assert.NoError(t, err)
+
+  serverDir, err := ioutil.TempDir("", "s3compat-server")
+  assert.NoError(t, err)
+  defer os.RemoveAll(serverDir)
+  assert.NoError(t, os.MkdirAll(filepath.Join(serverDir, "world", "region"), 0755))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "world", "level.dat"), []byte("level"), 0644))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "server.properties"), []byte("level-name=world\n"), 0644))
+
+  store, err := NewArchiveStore("s3://craft-archives", sess)
+  assert.NoError(t, err)
+
+  // Archive.
+  s := &mclib.Server{User: "testuser", Name: "testserver", ServerDirectory: serverDir}
+  result, err := TakeSnapshot(s, mclib.WorldSnapshot, store, SnapshotOptions{})
+  if assert.NoError(t, err) {
+    assert.Equal(t, 1, result.Files)
+    assert.True(t, strings.HasPrefix(result.URI, "s3://craft-archives/testuser/testserver/WorldSnapshot/"))
+  }
+
+  // Publish.
+  key := ArchiveKey("testuser", "testserver", mclib.MiscSnapshot, time.Now(), ".zip")
+  obj, err := store.Put(key, strings.NewReader("published"))
+  if assert.NoError(t, err) {
+    assert.Equal(t, etag([]byte("published")), obj.ETag)
+  }
+
+  // List.
+  al, err := ListArchives(store, "testuser")
+  assert.NoError(t, err)
+  assert.Equal(t, 2, len(al))
+
+  // And get it back.
+  r, err := store.Get(key)
+  if assert.NoError(t, err) {
+    b, _ := ioutil.ReadAll(r)
+    r.Close()
+    assert.Equal(t, "published", string(b))
+  }
+}
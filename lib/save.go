@@ -0,0 +1,104 @@
+package lib
+
+import(
+  "context"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
+)
+
+// Before we read the server's files it has to stop writing them and
+// have everything on disk. `save-all flush` doesn't return until the save
+// is done, and answers "Saved the game" from 1.13 on, "Saved the world"
+// before that. If the answer doesn't say the save is done, as with servers
+// that save in the background, we watch logs/latest.log for it.
+const(
+  DefaultSaveTimeout = 30 * time.Second
+  saveLogPoll = 100 * time.Millisecond
+)
+
+var savedMessages = []string{"Saved the game", "Saved the world"}
+
+func saysSaved(s string) (bool) {
+  for _, m := range savedMessages {
+    if strings.Contains(s, m) { return true }
+  }
+  return false
+}
+
+// Turn saving off and flush the world to disk, waiting up to timeout
+// (DefaultSaveTimeout if 0) or until ctx is done for the server to say it's saved.
+// resume turns saving back on. It's never nil and should be called,
+// deferred, whether or not there's an error.
+func PauseSaving(ctx context.Context, rcon RconSender, serverDir string, timeout time.Duration) (resume func() (error), err error) {
+  resume = func() (error) {
+    // Even if ctx is done, or a timed out command broke the connection,
+    // saving has to go back on.
+    if rc, ok := rcon.(*RconClient); ok && rc.Broken() {
+      rctx, cancel := context.WithTimeout(context.Background(), DefaultRconTimeout)
+      defer cancel()
+      if err := rc.Reconnect(rctx); err != nil { return fmt.Errorf("Couldn't turn saving back on: %s", err) }
+    }
+    if _, err := rcon.Send("save-on"); err != nil { return fmt.Errorf("Couldn't turn saving back on: %s", err) }
+    return nil
+  }
+  if timeout <= 0 { timeout = DefaultSaveTimeout }
+
+  if _, err = sendContext(ctx, rcon, "save-off"); err != nil { return resume, fmt.Errorf("Couldn't turn saving off: %s", err) }
+  logFile := filepath.Join(serverDir, "logs", "latest.log")
+  offset := fileSize(logFile)
+  // A big world can take longer to flush than a command usually gets.
+  resp, err := sendTimeout(ctx, rcon, "save-all flush", timeout)
+  if err != nil { return resume, fmt.Errorf("Couldn't save the world: %s", err) }
+  if saysSaved(formatRconResp(resp)) { return resume, nil }
+
+  if err = waitForSave(ctx, logFile, offset, timeout); err != nil {
+    return resume, fmt.Errorf("Couldn't confirm the world was saved: %s", err)
+  }
+  return resume, nil
+}
+
+func fileSize(name string) (int64) {
+  info, err := os.Stat(name)
+  if err != nil { return 0 }
+  return info.Size()
+}
+
+// Wait for the save to be logged after offset.
+func waitForSave(ctx context.Context, logFile string, offset int64, timeout time.Duration) (error) {
+  deadline := time.NewTimer(timeout)
+  defer deadline.Stop()
+  poll := time.NewTicker(saveLogPoll)
+  defer poll.Stop()
+  for {
+    // The log may have been rolled over since we looked.
+    if fileSize(logFile) < offset { offset = 0 }
+    if found, err := logSavedSince(logFile, offset); err != nil {
+      return err
+    } else if found {
+      return nil
+    }
+    select {
+    case <- ctx.Done():
+      return ctx.Err()
+    case <- deadline.C:
+      return fmt.Errorf("No \"%s\" from the server after %s", strings.Join(savedMessages, "\" or \""), timeout)
+    case <- poll.C:
+    }
+  }
+}
+
+func logSavedSince(logFile string, offset int64) (bool, error) {
+  file, err := os.Open(logFile)
+  if os.IsNotExist(err) { return false, nil }
+  if err != nil { return false, err }
+  defer file.Close()
+  if _, err = file.Seek(offset, io.SeekStart); err != nil { return false, err }
+  b, err := ioutil.ReadAll(file)
+  if err != nil { return false, err }
+  return saysSaved(string(b)), nil
+}
@@ -0,0 +1,185 @@
+package lib
+
+import (
+  "context"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sync"
+  "testing"
+  "time"
+  "craft-config/lib/rcontest"
+  "github.com/stretchr/testify/assert"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+// Records commands and answers save-all with saveResp.
+type fakeSender struct {
+  mu sync.Mutex
+  sent []string
+  saveResp string
+  fail string
+}
+
+func (f *fakeSender) Send(cmd string) (string, error) {
+  f.mu.Lock()
+  defer f.mu.Unlock()
+  f.sent = append(f.sent, cmd)
+  if cmd == f.fail { return "", fmt.Errorf("Connection reset.") }
+  if cmd == "save-all flush" { return f.saveResp, nil }
+  return "", nil
+}
+
+func TestPauseSaving(t *testing.T) {
+  dir, err := ioutil.TempDir("", "pause-saving")
+  assert.NoError(t, err)
+  defer os.RemoveAll(dir)
+  assert.NoError(t, os.MkdirAll(filepath.Join(dir, "logs"), 0755))
+  logFile := filepath.Join(dir, "logs", "latest.log")
+  assert.NoError(t, ioutil.WriteFile(logFile, []byte("[12:00:00] [Server thread/INFO]: Saved the game\n"), 0644))
+
+  // Newer servers answer when the save is done.
+  rc := &fakeSender{saveResp: "Saving the game (this may take a moment!)Saved the game"}
+  resume, err := PauseSaving(context.Background(), rc, dir, time.Second)
+  assert.NoError(t, err)
+  assert.NoError(t, resume())
+  assert.Equal(t, []string{"save-off", "save-all flush", "save-on"}, rc.sent)
+
+  // Older ones log it later, an earlier save in the log doesn't count.
+  rc = &fakeSender{saveResp: "Saving..."}
+  go func() {
+    time.Sleep(200 * time.Millisecond)
+    file, _ := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+    file.WriteString("[12:05:00] [Server thread/INFO]: Saved the game\n")
+    file.Close()
+  }()
+  start := time.Now()
+  _, err = PauseSaving(context.Background(), rc, dir, 5 * time.Second)
+  assert.NoError(t, err)
+  assert.True(t, time.Since(start) >= 200 * time.Millisecond)
+
+  // 1.12 and earlier say "Saved the world", in the answer or the log.
+  rc = &fakeSender{saveResp: "Saving...Saved the world"}
+  _, err = PauseSaving(context.Background(), rc, dir, 300 * time.Millisecond)
+  assert.NoError(t, err)
+  rc = &fakeSender{saveResp: "Saving..."}
+  go func() {
+    time.Sleep(200 * time.Millisecond)
+    file, _ := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0644)
+    file.WriteString("[12:10:00] [Server thread/INFO]: Saved the world\n")
+    file.Close()
+  }()
+  _, err = PauseSaving(context.Background(), rc, dir, 5 * time.Second)
+  assert.NoError(t, err)
+
+  // No acknowledgement.
+  _, err = PauseSaving(context.Background(), rc, dir, 300 * time.Millisecond)
+  assert.Error(t, err)
+
+  // Saving goes back on even when turning it off failed.
+  rc = &fakeSender{fail: "save-off"}
+  resume, err = PauseSaving(context.Background(), rc, dir, time.Second)
+  assert.Error(t, err)
+  assert.NoError(t, resume())
+  assert.Equal(t, []string{"save-off", "save-on"}, rc.sent)
+}
+
+func TestPauseSavingSlowFlush(t *testing.T) {
+  srv, err := rcontest.NewServer("secret")
+  if !assert.NoError(t, err) { return }
+  defer srv.Close()
+  flush := 300 * time.Millisecond
+  srv.Handle(func(cmd string) (string, bool) {
+    if cmd != "save-all flush" { return "", false }
+    time.Sleep(flush)
+    return "Saved the game", true
+  })
+  c, err := DialRcon(context.Background(), srv.Addr(), "secret")
+  if !assert.NoError(t, err) { return }
+  defer c.Close()
+  c.Timeout = 100 * time.Millisecond
+
+  // The flush has the save timeout, not the client's.
+  resume, err := PauseSaving(context.Background(), c, "", time.Second)
+  assert.NoError(t, err)
+  assert.NoError(t, resume())
+
+  // Timing out breaks the connection, saving goes back on over a new one.
+  resume, err = PauseSaving(context.Background(), c, "", 100 * time.Millisecond)
+  assert.Error(t, err)
+  assert.True(t, c.Broken())
+  assert.NoError(t, resume())
+  assert.Equal(t, []string{"save-off", "save-all flush", "save-on", "save-off", "save-all flush", "save-on"}, srv.Commands())
+  assert.Equal(t, 2, srv.Logins())
+}
+
+func TestPauseSavingLegacy(t *testing.T) {
+  srv, err := rcontest.NewServer("secret")
+  if !assert.NoError(t, err) { return }
+  defer srv.Close()
+  srv.SetLegacy(true)
+  c, err := DialRcon(context.Background(), srv.Addr(), "secret")
+  if !assert.NoError(t, err) { return }
+  defer c.Close()
+
+  // No log to fall back on, the answer has to do.
+  resume, err := PauseSaving(context.Background(), c, "", 300 * time.Millisecond)
+  assert.NoError(t, err)
+  assert.NoError(t, resume())
+  assert.Equal(t, []string{"save-off", "save-all flush", "save-on"}, srv.Commands())
+}
+
+// Records whether the lock was held for each command and upload.
+type lockRecorder struct {
+  sync.Mutex
+  held bool
+  log []string
+}
+
+func (l *lockRecorder) Lock() { l.Mutex.Lock(); l.held = true }
+func (l *lockRecorder) Unlock() { l.held = false; l.Mutex.Unlock() }
+
+func (l *lockRecorder) record(what string) {
+  l.log = append(l.log, fmt.Sprintf("%s %t", what, l.held))
+}
+
+type recordingSender struct { l *lockRecorder }
+
+func (r recordingSender) Send(cmd string) (string, error) {
+  r.l.record(cmd)
+  return "Saved the game", nil
+}
+
+type recordingStore struct {
+  ArchiveStore
+  l *lockRecorder
+}
+
+func (r recordingStore) Put(key string, rd io.Reader) (*StoredObject, error) {
+  r.l.record("put")
+  return r.ArchiveStore.Put(key, rd)
+}
+
+func TestSnapshotRconLock(t *testing.T) {
+  dir, err := ioutil.TempDir("", "rcon-lock")
+  assert.NoError(t, err)
+  defer os.RemoveAll(dir)
+  serverDir := filepath.Join(dir, "server")
+  assert.NoError(t, os.MkdirAll(filepath.Join(serverDir, "world"), 0755))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "world", "level.dat"), []byte("level"), 0644))
+
+  // Only pausing and resuming saving hold the lock, not the upload.
+  l := &lockRecorder{}
+  store := recordingStore{NewFileStore(filepath.Join(dir, "archives")), l}
+  s := &mclib.Server{User: "testuser", Name: "testserver", ServerDirectory: serverDir}
+  _, err = TakeSnapshot(s, mclib.WorldSnapshot, store, SnapshotOptions{Rcon: recordingSender{l}, RconLock: l})
+  assert.NoError(t, err)
+  assert.Equal(t, "save-off true", l.log[0])
+  assert.Equal(t, "save-all flush true", l.log[1])
+  assert.Contains(t, l.log, "put false")
+  assert.Equal(t, "save-on true", l.log[len(l.log) - 1])
+}
@@ -0,0 +1,193 @@
+package lib
+
+import(
+  "fmt"
+  "strconv"
+  "strings"
+  "time"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+// Standard five field cron expressions: minute hour day-of-month month day-of-week.
+// Each field is *, a number, a range a-b, a step */n or a-b/n, or a comma
+// separated list of these. Day of week is 0-6 with Sunday as 0 (7 works too).
+// As with cron, if both day fields are restricted either one matching is enough.
+// @hourly, @daily, @weekly and @monthly are also understood.
+type CronSchedule struct {
+  spec string
+  minute, hour, dom, month, dow uint64
+  domStar, dowStar bool
+}
+
+var cronShortcuts = map[string]string{
+  "@hourly": "0 * * * *",
+  "@daily": "0 0 * * *",
+  "@weekly": "0 0 * * 0",
+  "@monthly": "0 0 1 * *",
+}
+
+type cronField struct {
+  name string
+  min, max int
+}
+
+var cronFields = []cronField{
+  {"minute", 0, 59},
+  {"hour", 0, 23},
+  {"day of month", 1, 31},
+  {"month", 1, 12},
+  {"day of week", 0, 7},
+}
+
+func ParseCron(spec string) (*CronSchedule, error) {
+  expr := strings.TrimSpace(spec)
+  if s, ok := cronShortcuts[expr]; ok { expr = s }
+  fields := strings.Fields(expr)
+  if len(fields) != len(cronFields) {
+    return nil, fmt.Errorf("Cron schedule \"%s\" needs 5 fields: minute hour day-of-month month day-of-week", spec)
+  }
+
+  c := &CronSchedule{spec: spec}
+  bits := make([]uint64, len(fields))
+  for i, field := range fields {
+    b, err := parseCronField(field, cronFields[i])
+    if err != nil { return nil, fmt.Errorf("Cron schedule \"%s\": %s", spec, err) }
+    bits[i] = b
+  }
+  c.minute, c.hour, c.dom, c.month, c.dow = bits[0], bits[1], bits[2], bits[3], bits[4]
+  // Sunday is 0 or 7.
+  if c.dow & (1 << 7) != 0 { c.dow |= 1 }
+  c.domStar = fields[2] == "*"
+  c.dowStar = fields[4] == "*"
+  if c.Next(time.Now()).IsZero() { return nil, fmt.Errorf("Cron schedule \"%s\" never runs.", spec) }
+  return c, nil
+}
+
+func parseCronField(field string, cf cronField) (bits uint64, err error) {
+  for _, part := range strings.Split(field, ",") {
+    lo, hi, step := cf.min, cf.max, 1
+    rng := part
+    if i := strings.Index(part, "/"); i >= 0 {
+      rng = part[:i]
+      if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+        return 0, fmt.Errorf("bad step in %s field: %s", cf.name, part)
+      }
+    }
+    if rng != "*" {
+      bounds := strings.SplitN(rng, "-", 2)
+      if lo, err = strconv.Atoi(bounds[0]); err != nil { return 0, fmt.Errorf("bad %s: %s", cf.name, part) }
+      hi = lo
+      if len(bounds) == 2 {
+        if hi, err = strconv.Atoi(bounds[1]); err != nil { return 0, fmt.Errorf("bad %s: %s", cf.name, part) }
+      } else if step > 1 {
+        hi = cf.max
+      }
+    }
+    if lo < cf.min || hi > cf.max || lo > hi {
+      return 0, fmt.Errorf("%s out of range %d-%d: %s", cf.name, cf.min, cf.max, part)
+    }
+    for v := lo; v <= hi; v += step {
+      bits |= 1 << uint(v)
+    }
+  }
+  return bits, nil
+}
+
+func (c *CronSchedule) String() (string) {
+  return c.spec
+}
+
+func (c *CronSchedule) dayMatches(t time.Time) (bool) {
+  domOK := c.dom & (1 << uint(t.Day())) != 0
+  dowOK := c.dow & (1 << uint(t.Weekday())) != 0
+  if c.domStar || c.dowStar { return domOK && dowOK }
+  return domOK || dowOK
+}
+
+// The first time after t that matches the schedule, in t's location.
+func (c *CronSchedule) Next(t time.Time) (time.Time) {
+  t = t.Truncate(time.Minute).Add(time.Minute)
+  // Every schedule matches at least once in a leap cycle.
+  limit := t.AddDate(5, 0, 0)
+  for t.Before(limit) {
+    switch {
+    case c.month & (1 << uint(t.Month())) == 0:
+      t = time.Date(t.Year(), t.Month() + 1, 1, 0, 0, 0, 0, t.Location())
+    case !c.dayMatches(t):
+      t = time.Date(t.Year(), t.Month(), t.Day() + 1, 0, 0, 0, 0, t.Location())
+    case c.hour & (1 << uint(t.Hour())) == 0:
+      t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour() + 1, 0, 0, 0, t.Location())
+    case c.minute & (1 << uint(t.Minute())) == 0:
+      t = t.Add(time.Minute)
+    default:
+      return t
+    }
+  }
+  return time.Time{}
+}
+
+// A cron schedule for one kind of snapshot.
+type SnapshotSchedule struct {
+  Type mclib.ArchiveType
+  Cron *CronSchedule
+}
+
+// From ArchiveType name to cron expression, e.g. WorldSnapshot="*/5 * * * *".
+func ParseSnapshotSchedules(specs map[string]string) ([]SnapshotSchedule, error) {
+  schedules := make([]SnapshotSchedule, 0, len(specs))
+  for name, spec := range specs {
+    var aType mclib.ArchiveType
+    switch name {
+    case mclib.ServerSnapshot.String(): aType = mclib.ServerSnapshot
+    case mclib.WorldSnapshot.String(): aType = mclib.WorldSnapshot
+    default:
+      return nil, fmt.Errorf("Can't schedule \"%s\": use %s or %s", name, mclib.ServerSnapshot.String(), mclib.WorldSnapshot.String())
+    }
+    c, err := ParseCron(spec)
+    if err != nil { return nil, err }
+    schedules = append(schedules, SnapshotSchedule{Type: aType, Cron: c})
+  }
+  return schedules, nil
+}
+
+// A daily window, in local time, when snapshots aren't taken.
+// Windows may wrap past midnight, e.g. 23:00-06:00.
+type QuietHours struct {
+  Start time.Duration // Since midnight.
+  End time.Duration
+}
+
+func ParseQuietHours(s string) (q QuietHours, err error) {
+  parts := strings.Split(strings.TrimSpace(s), "-")
+  if len(parts) != 2 { return q, fmt.Errorf("Quiet hours should look like HH:MM-HH:MM: %s", s) }
+  if q.Start, err = parseClock(parts[0]); err != nil { return q, err }
+  if q.End, err = parseClock(parts[1]); err != nil { return q, err }
+  return q, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+  t, err := time.Parse("15:04", strings.TrimSpace(s))
+  if err != nil { return 0, fmt.Errorf("Bad time of day \"%s\", use HH:MM", s) }
+  return time.Duration(t.Hour()) * time.Hour + time.Duration(t.Minute()) * time.Minute, nil
+}
+
+func (q QuietHours) Contains(t time.Time) (bool) {
+  d := time.Duration(t.Hour()) * time.Hour + time.Duration(t.Minute()) * time.Minute
+  if q.Start <= q.End { return d >= q.Start && d < q.End }
+  return d >= q.Start || d < q.End
+}
+
+func (q QuietHours) String() (string) {
+  clock := func(d time.Duration) string { return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes()) % 60) }
+  return clock(q.Start) + "-" + clock(q.End)
+}
+
+// Is t in any of the windows?
+func InQuietHours(windows []QuietHours, t time.Time) (bool) {
+  for _, q := range windows {
+    if q.Contains(t) { return true }
+  }
+  return false
+}
@@ -0,0 +1,69 @@
+package lib
+
+import (
+  "testing"
+  "time"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestCronNext(t *testing.T) {
+  // A Wednesday.
+  start := time.Date(2017, 3, 15, 10, 7, 30, 0, time.UTC)
+  tests := []struct {
+    spec string
+    next time.Time
+  }{
+    {"*/5 * * * *", time.Date(2017, 3, 15, 10, 10, 0, 0, time.UTC)},
+    {"@hourly", time.Date(2017, 3, 15, 11, 0, 0, 0, time.UTC)},
+    {"30 2 * * *", time.Date(2017, 3, 16, 2, 30, 0, 0, time.UTC)},
+    {"0 0 * * 0", time.Date(2017, 3, 19, 0, 0, 0, 0, time.UTC)},
+    {"0 0 * * 7", time.Date(2017, 3, 19, 0, 0, 0, 0, time.UTC)},
+    {"0 9-17/4 * * 1-5", time.Date(2017, 3, 15, 13, 0, 0, 0, time.UTC)},
+    {"0 0 1 1,7 *", time.Date(2017, 7, 1, 0, 0, 0, 0, time.UTC)},
+    // Either day field can match when both are restricted.
+    {"0 0 20 * 5", time.Date(2017, 3, 17, 0, 0, 0, 0, time.UTC)},
+    {"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+  }
+  for _, tt := range tests {
+    c, err := ParseCron(tt.spec)
+    if assert.NoError(t, err, tt.spec) {
+      assert.Equal(t, tt.next, c.Next(start), tt.spec)
+    }
+  }
+
+  for _, bad := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "0 0 30 2 *"} {
+    _, err := ParseCron(bad)
+    assert.Error(t, err, bad)
+  }
+}
+
+func TestSnapshotSchedules(t *testing.T) {
+  ss, err := ParseSnapshotSchedules(map[string]string{"WorldSnapshot": "*/5 * * * *"})
+  assert.NoError(t, err)
+  assert.Equal(t, 1, len(ss))
+  _, err = ParseSnapshotSchedules(map[string]string{"MiscSnapshot": "@hourly"})
+  assert.Error(t, err)
+}
+
+func TestQuietHours(t *testing.T) {
+  at := func(h, m int) time.Time { return time.Date(2017, 3, 15, h, m, 0, 0, time.Local) }
+
+  q, err := ParseQuietHours("02:00-06:30")
+  assert.NoError(t, err)
+  assert.Equal(t, "02:00-06:30", q.String())
+  assert.True(t, q.Contains(at(2, 0)))
+  assert.True(t, q.Contains(at(6, 29)))
+  assert.False(t, q.Contains(at(6, 30)))
+  assert.False(t, q.Contains(at(1, 59)))
+
+  overnight, err := ParseQuietHours("23:00-01:00")
+  assert.NoError(t, err)
+  assert.True(t, overnight.Contains(at(23, 30)))
+  assert.True(t, overnight.Contains(at(0, 30)))
+  assert.False(t, overnight.Contains(at(12, 0)))
+  assert.True(t, InQuietHours([]QuietHours{q, overnight}, at(0, 10)))
+  assert.False(t, InQuietHours([]QuietHours{q, overnight}, at(12, 0)))
+
+  _, err = ParseQuietHours("2am-6am")
+  assert.Error(t, err)
+}
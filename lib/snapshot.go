@@ -0,0 +1,204 @@
+package lib
+
+import(
+  "context"
+  "fmt"
+  "io"
+  "os"
+  "path/filepath"
+  "sync"
+  "time"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+// What we get back from taking and storing a snapshot.
+type SnapshotResult struct {
+  Type mclib.ArchiveType
+  URI string
+  Files int
+  Object *StoredObject
+  Uploaded int64 // Bytes of data sent: the archive, or the new blobs of an incremental snapshot.
+}
+
+// The files and directories, relative to the server directory, that go into
+// an archive of the given type. MiscSnapshots take the files they're given.
+func SnapshotFiles(serverDir string, aType mclib.ArchiveType, files []string) ([]string, error) {
+  switch aType {
+  case mclib.ServerSnapshot:
+    return []string{"."}, nil
+  case mclib.WorldSnapshot:
+    level := LevelName(serverDir)
+    worlds := make([]string, 0, 3)
+    for _, w := range []string{level, level + "_nether", level + "_the_end"} {
+      if _, err := os.Stat(filepath.Join(serverDir, w)); err == nil {
+        worlds = append(worlds, w)
+      }
+    }
+    if len(worlds) == 0 { return nil, fmt.Errorf("No world directory \"%s\" found in %s", level, serverDir) }
+    return worlds, nil
+  case mclib.MiscSnapshot:
+    if len(files) == 0 { return nil, fmt.Errorf("Need at least one file for a MiscSnapshot archive.") }
+    return files, nil
+  }
+  return nil, fmt.Errorf("Error archiving: Bad ArchiveType: %s", aType.String())
+}
+
+// How to take a snapshot.
+type SnapshotOptions struct {
+  Files []string // Only for MiscSnapshots.
+  Incremental bool // Store content-addressed blobs and a manifest rather than an archive file.
+  Exclude []string // Exclude patterns, on top of the server's .craftignore.
+  Format ArchiveFormat // Defaults to zip.
+  Encryption *Encryption // Encrypt the archive and its manifest, nil for none.
+  SaveTimeout time.Duration // How long to wait for the server to save, 0 for DefaultSaveTimeout.
+  Rcon RconSender // Pause saving over this rather than the server's mclib connection.
+  RconLock sync.Locker // Held while pausing and while resuming saving, not in between, if set.
+}
+
+type noLock struct{}
+
+func (noLock) Lock() {}
+func (noLock) Unlock() {}
+
+// Take a snapshot of the server and put it in the store.
+// If there's an RCON connection, in opts or the server's, saving is turned off and the
+// world flushed to disk before we read the files, and saving is turned
+// back on when we're done, however that is.
+func TakeSnapshot(s *mclib.Server, aType mclib.ArchiveType, store ArchiveStore, opts SnapshotOptions) (result *SnapshotResult, err error) {
+  return TakeSnapshotContext(context.Background(), s, aType, store, opts)
+}
+
+// As TakeSnapshot, but gives up, leaving nothing behind but perhaps
+// some incremental blobs, when ctx is done.
+func TakeSnapshotContext(ctx context.Context, s *mclib.Server, aType mclib.ArchiveType, store ArchiveStore, opts SnapshotOptions) (result *SnapshotResult, err error) {
+  f := s.LogFields()
+  f["serverDir"] = s.ServerDirectory
+  f["snapshotType"] = aType.String()
+
+  if opts.Incremental && opts.Encryption.Encrypts() {
+    return nil, fmt.Errorf("Incremental snapshots can't be encrypted: blobs are stored by their checksum.")
+  }
+  files, err := SnapshotFiles(s.ServerDirectory, aType, opts.Files)
+  if err != nil { return nil, err }
+  rules, err := LoadIgnoreRules(s.ServerDirectory, opts.Exclude)
+  if err != nil { return nil, err }
+
+  rcon := opts.Rcon
+  if rcon == nil && s.HasRconConnection() { rcon = s.Rcon }
+  if rcon != nil {
+    lock := opts.RconLock
+    if lock == nil { lock = noLock{} }
+    lock.Lock()
+    resume, err := PauseSaving(ctx, rcon, s.ServerDirectory, opts.SaveTimeout)
+    lock.Unlock()
+    defer func() {
+      lock.Lock()
+      defer lock.Unlock()
+      if rerr := resume(); rerr != nil { log.Error(f, "Couldn't turn saving back on.", rerr) }
+    }()
+    if err != nil { return nil, err }
+  } else {
+    log.Debug(f, "No RCON connection, archiving without stopping saves.")
+  }
+
+  if opts.Incremental {
+    return putIncrementalSnapshot(ctx, s, aType, files, rules, store)
+  }
+
+  format := opts.Format
+  if format == "" { format = DefaultFormat }
+  ext := format.Ext()
+  if opts.Encryption.Encrypts() { ext += encryptedExt }
+  key := ArchiveKey(s.User, s.Name, aType, time.Now(), ext)
+  manifest := NewManifest(s, aType)
+
+  // Stream the archive straight from the file system to the store.
+  pr, pw := io.Pipe()
+  written := make(chan error, 1)
+  go func() {
+    var werr error
+    // Don't take the process down before saving is back on.
+    defer func() {
+      if p := recover(); p != nil {
+        werr = fmt.Errorf("Archiving panicked: %v", p)
+        pw.CloseWithError(werr)
+        written <- werr
+      }
+    }()
+    var aw io.WriteCloser = nopCloser{&contextWriter{ctx: ctx, w: pw}}
+    if opts.Encryption.Encrypts() {
+      if aw, werr = opts.Encryption.Encrypt(&contextWriter{ctx: ctx, w: pw}); werr != nil {
+        pw.CloseWithError(werr)
+        written <- werr
+        return
+      }
+    }
+    manifest.Files, werr = WriteArchive(aw, format, s.ServerDirectory, files, rules)
+    if werr == nil { werr = aw.Close() }
+    pw.CloseWithError(werr)
+    written <- werr
+  }()
+  // The store may be stuck reading, unstick it if we're cancelled.
+  putDone := make(chan struct{})
+  defer close(putDone)
+  go func() {
+    select {
+    case <- ctx.Done():
+      pr.CloseWithError(ctx.Err())
+    case <- putDone:
+    }
+  }()
+  cr := &countingReader{r: pr}
+  obj, err := store.Put(key, cr)
+  if err != nil {
+    // Stop the writer if the store gave up early.
+    pr.CloseWithError(err)
+    if werr := <-written; werr != nil && werr != err { return nil, fmt.Errorf("Couldn't create archive: %s", werr) }
+    return nil, err
+  }
+  if err = <-written; err != nil { return nil, fmt.Errorf("Couldn't create archive: %s", err) }
+  obj.Size = cr.n
+
+  if _, err = putManifest(store, ManifestKey(key), manifest, opts.Encryption); err != nil {
+    return nil, fmt.Errorf("Couldn't publish manifest for %s: %s", store.URI(key), err)
+  }
+
+  result = &SnapshotResult{
+    Type: aType,
+    URI: store.URI(key),
+    Files: len(manifest.Files),
+    Object: obj,
+    Uploaded: obj.Size,
+  }
+  return result, nil
+}
+
+// Fails writes once the context is done.
+type contextWriter struct {
+  ctx context.Context
+  w io.Writer
+}
+
+func (c *contextWriter) Write(p []byte) (int, error) {
+  if err := c.ctx.Err(); err != nil { return 0, err }
+  return c.w.Write(p)
+}
+
+type nopCloser struct {
+  io.Writer
+}
+
+func (nopCloser) Close() (error) { return nil }
+
+type countingReader struct {
+  r io.Reader
+  n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+  n, err := c.r.Read(p)
+  c.n += int64(n)
+  return n, err
+}
@@ -0,0 +1,226 @@
+package lib
+
+import(
+  "context"
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+  "github.com/Sirupsen/logrus"
+)
+
+// A local directory holding uploads that failed, waiting to be retried.
+// Each upload is a pair of files: <id>.data and <id>.json describing it.
+// The spool never holds more than Limit bytes of data.
+const(
+  spoolDataExt = ".data"
+  spoolMetaExt = ".json"
+  spoolTempPrefix = ".spooling-"
+)
+
+type Spool struct {
+  Dir string
+  Limit int64
+  mu sync.Mutex
+  seq int
+}
+
+type SpoolEntry struct {
+  Key string `json:"key"`
+  URI string `json:"uri"`
+  Size int64 `json:"size"`
+  Created time.Time `json:"created"`
+  Attempts int `json:"attempts"`
+  LastAttempt time.Time `json:"lastAttempt"`
+  LastError string `json:"lastError"`
+  id string
+}
+
+func OpenSpool(dir string, limit int64) (*Spool, error) {
+  if err := os.MkdirAll(dir, 0700); err != nil { return nil, err }
+  return &Spool{Dir: dir, Limit: limit}, nil
+}
+
+func (sp *Spool) dataFile(id string) (string) { return filepath.Join(sp.Dir, id + spoolDataExt) }
+func (sp *Spool) metaFile(id string) (string) { return filepath.Join(sp.Dir, id + spoolMetaExt) }
+
+// Oldest first.
+func (sp *Spool) Entries() ([]SpoolEntry, error) {
+  names, err := filepath.Glob(filepath.Join(sp.Dir, "*" + spoolMetaExt))
+  if err != nil { return nil, err }
+  entries := make([]SpoolEntry, 0, len(names))
+  for _, name := range names {
+    b, err := ioutil.ReadFile(name)
+    if err != nil { return nil, err }
+    e := SpoolEntry{}
+    if err = json.Unmarshal(b, &e); err != nil { return nil, fmt.Errorf("Bad spool entry %s: %s", name, err) }
+    e.id = strings.TrimSuffix(filepath.Base(name), spoolMetaExt)
+    entries = append(entries, e)
+  }
+  sort.Sort(spoolByAge(entries))
+  return entries, nil
+}
+
+type spoolByAge []SpoolEntry
+func (a spoolByAge) Len() int { return len(a) }
+func (a spoolByAge) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a spoolByAge) Less(i, j int) bool { return a[i].id < a[j].id }
+
+// Bytes of data waiting in the spool.
+func (sp *Spool) Size() (size int64, err error) {
+  entries, err := sp.Entries()
+  if err != nil { return 0, err }
+  for _, e := range entries { size += e.Size }
+  return size, nil
+}
+
+func (sp *Spool) save(e SpoolEntry) (error) {
+  b, err := json.MarshalIndent(e, "", "  ")
+  if err != nil { return err }
+  tmp := filepath.Join(sp.Dir, spoolTempPrefix + e.id + spoolMetaExt)
+  if err = ioutil.WriteFile(tmp, b, 0600); err != nil { return err }
+  return os.Rename(tmp, sp.metaFile(e.id))
+}
+
+func (sp *Spool) remove(e SpoolEntry) (error) {
+  if err := os.Remove(sp.metaFile(e.id)); err != nil && !os.IsNotExist(err) { return err }
+  return os.Remove(sp.dataFile(e.id))
+}
+
+// Ids sort in the order they were spooled.
+func (sp *Spool) newId() (string) {
+  sp.mu.Lock()
+  defer sp.mu.Unlock()
+  sp.seq++
+  return fmt.Sprintf("%s-%06d", time.Now().UTC().Format("20060102T150405.000000000Z"), sp.seq)
+}
+
+// Writes until it's had limit bytes, then quietly drops the rest.
+type limitedWriter struct {
+  w io.Writer
+  limit int64
+  written int64
+  overflow bool
+  err error
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+  if l.overflow || l.err != nil { return len(p), nil }
+  if l.written + int64(len(p)) > l.limit {
+    l.overflow = true
+    return len(p), nil
+  }
+  n, err := l.w.Write(p)
+  l.written += int64(n)
+  if err != nil { l.err = err }
+  return len(p), nil
+}
+
+// An ArchiveStore that spools uploads that fail. Each upload is written
+// to a temporary file in the spool as the store reads it, up to the room
+// left in the spool, and the file is thrown away if the upload works.
+// If it fails, what the store didn't read is added and the file becomes
+// a spool entry. An upload bigger than the room left can't be spooled.
+type SpoolStore struct {
+  ArchiveStore
+  spool *Spool
+}
+
+func NewSpoolStore(store ArchiveStore, spool *Spool) (*SpoolStore) {
+  return &SpoolStore{ArchiveStore: store, spool: spool}
+}
+
+// If the upload fails but the data made it into the spool the object
+// comes back marked Spooled and there's no error.
+func (s *SpoolStore) Put(key string, r io.Reader) (obj *StoredObject, err error) {
+  f := logrus.Fields{"operation": "Spool", "uri": s.URI(key), "spoolDir": s.spool.Dir}
+  used, err := s.spool.Size()
+  if err != nil { return nil, err }
+  room := s.spool.Limit - used
+  if room <= 0 { return s.ArchiveStore.Put(key, r) }
+
+  id := s.spool.newId()
+  tmp, err := os.OpenFile(filepath.Join(s.spool.Dir, spoolTempPrefix + id + spoolDataExt), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+  if err != nil {
+    log.Error(f, "Can't write to the spool, uploading without it.", err)
+    return s.ArchiveStore.Put(key, r)
+  }
+  defer func() {
+    tmp.Close()
+    os.Remove(tmp.Name())
+  }()
+  lw := &limitedWriter{w: tmp, limit: room}
+  obj, err = s.ArchiveStore.Put(key, io.TeeReader(r, lw))
+  if err == nil { return obj, nil }
+
+  // The rest of the archive, that the store didn't read.
+  if _, cerr := io.Copy(lw, r); cerr != nil { return nil, err }
+  if lw.overflow || lw.err != nil {
+    f["spoolLimit"] = s.spool.Limit
+    log.Error(f, "Upload failed and there's no room to spool it.", err)
+    return nil, err
+  }
+  if cerr := tmp.Close(); cerr != nil { return nil, err }
+
+  e := SpoolEntry{Key: key, URI: s.URI(key), Size: lw.written, Created: time.Now().UTC(), LastError: err.Error(), id: id}
+  if rerr := os.Rename(tmp.Name(), s.spool.dataFile(id)); rerr != nil { return nil, err }
+  if serr := s.spool.save(e); serr != nil {
+    os.Remove(s.spool.dataFile(id))
+    return nil, err
+  }
+  f["size"] = e.Size
+  log.Error(f, "Upload failed, spooled for retry.", err)
+  return &StoredObject{Key: key, Size: e.Size, LastMod: e.Created, Spooled: true}, nil
+}
+
+// Upload what's in the spool, oldest first, stopping at the first failure.
+// Entries for other stores are left alone.
+func (s *SpoolStore) RetryOnce() (sent int, err error) {
+  entries, err := s.spool.Entries()
+  if err != nil { return 0, err }
+  for _, e := range entries {
+    if e.URI != s.URI(e.Key) { continue }
+    f := logrus.Fields{"operation": "Spool", "uri": e.URI, "attempts": e.Attempts + 1}
+    file, err := os.Open(s.spool.dataFile(e.id))
+    if err != nil { return sent, err }
+    _, err = s.ArchiveStore.Put(e.Key, file)
+    file.Close()
+    if err != nil {
+      e.Attempts++
+      e.LastAttempt = time.Now().UTC()
+      e.LastError = err.Error()
+      s.spool.save(e)
+      log.Debug(f, "Spooled upload failed again.")
+      return sent, err
+    }
+    if err = s.spool.remove(e); err != nil { return sent, err }
+    sent++
+    log.Info(f, "Uploaded spooled archive.")
+  }
+  return sent, nil
+}
+
+// Keep retrying until ctx is done, waiting base between passes and
+// doubling the wait, up to max, each time a pass fails.
+func (s *SpoolStore) Retry(ctx context.Context, base, max time.Duration) {
+  delay := base
+  for {
+    select {
+    case <- ctx.Done():
+      return
+    case <- time.After(delay):
+    }
+    if _, err := s.RetryOnce(); err != nil {
+      delay *= 2
+      if delay > max { delay = max }
+    } else {
+      delay = base
+    }
+  }
+}
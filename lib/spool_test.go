@@ -0,0 +1,117 @@
+package lib
+
+import (
+  "bytes"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "os"
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+// Reads part of the upload, 10 bytes or readFirst, and then fails, while down is set.
+type flakyStore struct {
+  *FileStore
+  down bool
+  readFirst int64
+}
+
+func (s *flakyStore) Put(key string, r io.Reader) (*StoredObject, error) {
+  if s.down {
+    n := s.readFirst
+    if n == 0 { n = 10 }
+    io.CopyN(ioutil.Discard, r, n)
+    return nil, fmt.Errorf("Store is down.")
+  }
+  return s.FileStore.Put(key, r)
+}
+
+func TestSpoolStore(t *testing.T) {
+  root, err := ioutil.TempDir("", "spool-store")
+  assert.NoError(t, err)
+  defer os.RemoveAll(root)
+  spoolDir, err := ioutil.TempDir("", "spool")
+  assert.NoError(t, err)
+  defer os.RemoveAll(spoolDir)
+
+  spool, err := OpenSpool(spoolDir, 100)
+  assert.NoError(t, err)
+  flaky := &flakyStore{FileStore: NewFileStore(root), down: true}
+  store := NewSpoolStore(flaky, spool)
+
+  first := bytes.Repeat([]byte("a"), 40)
+  obj, err := store.Put("u/s/WorldSnapshot/1.zip", bytes.NewReader(first))
+  assert.NoError(t, err)
+  assert.True(t, obj.Spooled)
+  assert.Equal(t, int64(40), obj.Size)
+  _, err = store.Put("u/s/WorldSnapshot/2.zip", bytes.NewReader([]byte("second")))
+  assert.NoError(t, err)
+
+  // No room left for this one.
+  _, err = store.Put("u/s/WorldSnapshot/3.zip", bytes.NewReader(bytes.Repeat([]byte("c"), 60)))
+  assert.Error(t, err)
+
+  entries, err := spool.Entries()
+  assert.NoError(t, err)
+  if assert.Len(t, entries, 2) {
+    assert.Equal(t, "u/s/WorldSnapshot/1.zip", entries[0].Key)
+    assert.Equal(t, "u/s/WorldSnapshot/2.zip", entries[1].Key)
+  }
+  size, err := spool.Size()
+  assert.NoError(t, err)
+  assert.Equal(t, int64(46), size)
+
+  sent, err := store.RetryOnce()
+  assert.Error(t, err)
+  assert.Equal(t, 0, sent)
+  entries, _ = spool.Entries()
+  assert.Equal(t, 1, entries[0].Attempts)
+  assert.Equal(t, 0, entries[1].Attempts)
+
+  flaky.down = false
+  sent, err = store.RetryOnce()
+  assert.NoError(t, err)
+  assert.Equal(t, 2, sent)
+  entries, _ = spool.Entries()
+  assert.Len(t, entries, 0)
+  b, err := ioutil.ReadFile(flaky.path("u/s/WorldSnapshot/1.zip"))
+  assert.NoError(t, err)
+  assert.Equal(t, first, b)
+
+  // Uploads that work don't touch the spool.
+  obj, err = store.Put("u/s/WorldSnapshot/4.zip", bytes.NewReader(first))
+  assert.NoError(t, err)
+  assert.False(t, obj.Spooled)
+  files, err := ioutil.ReadDir(spoolDir)
+  assert.NoError(t, err)
+  assert.Len(t, files, 0)
+}
+
+func TestSpoolStoreLateFailure(t *testing.T) {
+  root, err := ioutil.TempDir("", "spool-store")
+  assert.NoError(t, err)
+  defer os.RemoveAll(root)
+  spoolDir, err := ioutil.TempDir("", "spool")
+  assert.NoError(t, err)
+  defer os.RemoveAll(spoolDir)
+
+  // A server snapshot that fails well in to the upload is still spooled whole.
+  spool, err := OpenSpool(spoolDir, 64 << 20)
+  assert.NoError(t, err)
+  flaky := &flakyStore{FileStore: NewFileStore(root), down: true, readFirst: 20 << 20}
+  store := NewSpoolStore(flaky, spool)
+  big := bytes.Repeat([]byte("0123456789abcdef"), 24 << 16)
+  obj, err := store.Put("u/s/ServerSnapshot/1.zip", bytes.NewReader(big))
+  if !assert.NoError(t, err) { return }
+  assert.True(t, obj.Spooled)
+  assert.Equal(t, int64(len(big)), obj.Size)
+
+  flaky.down = false
+  sent, err := store.RetryOnce()
+  assert.NoError(t, err)
+  assert.Equal(t, 1, sent)
+  b, err := ioutil.ReadFile(flaky.path("u/s/ServerSnapshot/1.zip"))
+  assert.NoError(t, err)
+  assert.True(t, bytes.Equal(big, b))
+}
@@ -0,0 +1,271 @@
+package lib
+
+import(
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/url"
+  "os"
+  "path"
+  "path/filepath"
+  "strings"
+  "time"
+  "github.com/aws/aws-sdk-go/aws"
+  "github.com/aws/aws-sdk-go/aws/session"
+  "github.com/aws/aws-sdk-go/service/s3"
+  "github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Somewhere to keep archives.
+// Keys are slash separated paths relative to the root of the store.
+type ArchiveStore interface {
+  Put(key string, r io.Reader) (*StoredObject, error)
+  Get(key string) (io.ReadCloser, error)
+  List(prefix string) ([]StoredObject, error)
+  Delete(key string) (error)
+  URI(key string) (string)
+}
+
+type StoredObject struct {
+  Key string
+  Size int64
+  LastMod time.Time
+  ETag string
+  VersionId string
+  Spooled bool // Upload failed, it's waiting in the local spool (see spool.go).
+}
+
+const(
+  s3Scheme = "s3"
+  fileScheme = "file"
+)
+
+// Build a store from a URI: s3://bucket[/prefix] or file:///directory.
+// A bare name is taken to be an S3 bucket, which is how buckets
+// have always been specified.
+func NewArchiveStore(storeURI string, sess *session.Session) (ArchiveStore, error) {
+  if !strings.Contains(storeURI, "://") {
+    if storeURI == "" { return nil, fmt.Errorf("No archive store specified.") }
+    return NewS3Store(storeURI, "", sess), nil
+  }
+
+  u, err := url.Parse(storeURI)
+  if err != nil { return nil, fmt.Errorf("Bad archive store URI \"%s\": %s", storeURI, err) }
+  switch u.Scheme {
+  case s3Scheme:
+    if u.Host == "" { return nil, fmt.Errorf("Archive store URI needs a bucket: %s", storeURI) }
+    return NewS3Store(u.Host, u.Path, sess), nil
+  case fileScheme:
+    if u.Path == "" { return nil, fmt.Errorf("Archive store URI needs a directory: %s", storeURI) }
+    return NewFileStore(u.Path), nil
+  }
+  return nil, fmt.Errorf("Unsupported archive store \"%s\": use s3://bucket or file:///directory", storeURI)
+}
+
+// Split the URI of a single archive into the store that holds it and its key.
+func OpenArchiveURI(archiveURI string, sess *session.Session) (store ArchiveStore, key string, err error) {
+  u, err := url.Parse(archiveURI)
+  if err != nil { return nil, "", fmt.Errorf("Bad archive URI \"%s\": %s", archiveURI, err) }
+  switch u.Scheme {
+  case s3Scheme:
+    bucket, key, err := ParseS3URI(archiveURI)
+    if err != nil { return nil, "", err }
+    return NewS3Store(bucket, "", sess), key, nil
+  case fileScheme:
+    if u.Path == "" { return nil, "", fmt.Errorf("Archive URI needs a file: %s", archiveURI) }
+    // Find the root of the store from the archive layout so keys
+    // relative to the archive (e.g. incremental blobs) still work.
+    parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+    if isArchiveKey(u.Path) && len(parts) >= 4 {
+      key = strings.Join(parts[len(parts)-4:], "/")
+      root := "/" + strings.Join(parts[:len(parts)-4], "/")
+      return NewFileStore(root), key, nil
+    }
+    return NewFileStore(filepath.Dir(u.Path)), filepath.Base(u.Path), nil
+  }
+  return nil, "", fmt.Errorf("Archive URI must be of the form s3://bucket/key or file:///path: %s", archiveURI)
+}
+
+//
+// S3
+//
+
+// Lets us talk to S3-compatible services (MinIO, Ceph etc.) instead of AWS.
+// This only applies to archive storage, the rest of AWS is left alone.
+type S3Endpoint struct {
+  URL string
+  PathStyle bool
+  DisableSSL bool
+}
+
+var s3Endpoint S3Endpoint
+
+func SetS3Endpoint(e S3Endpoint) {
+  s3Endpoint = e
+}
+
+func GetS3Endpoint() (S3Endpoint) {
+  return s3Endpoint
+}
+
+func (e S3Endpoint) String() string {
+  if e.URL == "" { return "<aws>" }
+  return fmt.Sprintf("%s (path-style: %t, ssl: %t)", e.URL, e.PathStyle, !e.DisableSSL)
+}
+
+func (e S3Endpoint) config() (*aws.Config) {
+  c := aws.NewConfig()
+  if e.URL != "" { c = c.WithEndpoint(e.URL) }
+  if e.PathStyle { c = c.WithS3ForcePathStyle(true) }
+  if e.DisableSSL { c = c.WithDisableSSL(true) }
+  return c
+}
+
+type S3Store struct {
+  Bucket string
+  Prefix string
+  session *session.Session
+}
+
+func NewS3Store(bucket, prefix string, sess *session.Session) (*S3Store) {
+  return &S3Store{
+    Bucket: bucket,
+    Prefix: strings.Trim(prefix, "/"),
+    session: sess,
+  }
+}
+
+func (s *S3Store) client() (*s3.S3) {
+  return s3.New(s.session, s3Endpoint.config())
+}
+
+func (s *S3Store) fullKey(key string) (string) {
+  if s.Prefix == "" { return key }
+  return path.Join(s.Prefix, key)
+}
+
+func (s *S3Store) relativeKey(fullKey string) (string) {
+  if s.Prefix == "" { return fullKey }
+  return strings.TrimPrefix(fullKey, s.Prefix + "/")
+}
+
+func (s *S3Store) URI(key string) (string) {
+  return fmt.Sprintf("s3://%s/%s", s.Bucket, s.fullKey(key))
+}
+
+// The uploader will use a multipart upload for large archives.
+func (s *S3Store) Put(key string, r io.Reader) (*StoredObject, error) {
+  uploader := s3manager.NewUploaderWithClient(s.client())
+  resp, err := uploader.Upload(&s3manager.UploadInput{
+    Bucket: aws.String(s.Bucket),
+    Key: aws.String(s.fullKey(key)),
+    Body: r,
+  })
+  if err != nil { return nil, fmt.Errorf("Couldn't upload %s: %s", s.URI(key), err) }
+  obj := &StoredObject{
+    Key: key,
+    LastMod: time.Now(),
+  }
+  if resp.ETag != nil { obj.ETag = *resp.ETag }
+  if resp.VersionID != nil { obj.VersionId = *resp.VersionID }
+  return obj, nil
+}
+
+func (s *S3Store) Get(key string) (io.ReadCloser, error) {
+  resp, err := s.client().GetObject(&s3.GetObjectInput{
+    Bucket: aws.String(s.Bucket),
+    Key: aws.String(s.fullKey(key)),
+  })
+  if err != nil { return nil, fmt.Errorf("Couldn't get %s: %s", s.URI(key), err) }
+  return resp.Body, nil
+}
+
+func (s *S3Store) List(prefix string) ([]StoredObject, error) {
+  objects := make([]StoredObject, 0)
+  err := s.client().ListObjectsV2Pages(&s3.ListObjectsV2Input{
+    Bucket: aws.String(s.Bucket),
+    Prefix: aws.String(s.fullKey(prefix)),
+  }, func(page *s3.ListObjectsV2Output, last bool) (bool) {
+    for _, o := range page.Contents {
+      obj := StoredObject{Key: s.relativeKey(aws.StringValue(o.Key))}
+      obj.Size = aws.Int64Value(o.Size)
+      obj.LastMod = aws.TimeValue(o.LastModified)
+      obj.ETag = aws.StringValue(o.ETag)
+      objects = append(objects, obj)
+    }
+    return true
+  })
+  if err != nil { return nil, fmt.Errorf("Couldn't list %s: %s", s.URI(prefix), err) }
+  return objects, nil
+}
+
+func (s *S3Store) Delete(key string) (error) {
+  _, err := s.client().DeleteObject(&s3.DeleteObjectInput{
+    Bucket: aws.String(s.Bucket),
+    Key: aws.String(s.fullKey(key)),
+  })
+  if err != nil { return fmt.Errorf("Couldn't delete %s: %s", s.URI(key), err) }
+  return nil
+}
+
+//
+// Local directory
+//
+
+type FileStore struct {
+  Root string
+}
+
+func NewFileStore(root string) (*FileStore) {
+  return &FileStore{Root: filepath.Clean(root)}
+}
+
+func (s *FileStore) path(key string) (string) {
+  return filepath.Join(s.Root, filepath.FromSlash(key))
+}
+
+func (s *FileStore) URI(key string) (string) {
+  u := url.URL{Scheme: fileScheme, Path: filepath.ToSlash(s.path(key))}
+  return u.String()
+}
+
+// Write to a temporary file first so a partial archive never shows up under its key.
+func (s *FileStore) Put(key string, r io.Reader) (*StoredObject, error) {
+  p := s.path(key)
+  if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil { return nil, err }
+  tmp, err := ioutil.TempFile(filepath.Dir(p), "." + filepath.Base(p) + ".tmp-")
+  if err != nil { return nil, err }
+  size, err := io.Copy(tmp, r)
+  if err == nil { err = tmp.Close() } else { tmp.Close() }
+  if err == nil { err = os.Rename(tmp.Name(), p) }
+  if err != nil {
+    os.Remove(tmp.Name())
+    return nil, fmt.Errorf("Couldn't write %s: %s", s.URI(key), err)
+  }
+  return &StoredObject{Key: key, Size: size, LastMod: time.Now()}, nil
+}
+
+func (s *FileStore) Get(key string) (io.ReadCloser, error) {
+  return os.Open(s.path(key))
+}
+
+func (s *FileStore) List(prefix string) ([]StoredObject, error) {
+  objects := make([]StoredObject, 0)
+  if _, err := os.Stat(s.Root); os.IsNotExist(err) { return objects, nil }
+  err := filepath.Walk(s.Root, func(p string, info os.FileInfo, err error) (error) {
+    if err != nil { return err }
+    if info.IsDir() || strings.HasPrefix(info.Name(), ".") { return nil }
+    rel, err := filepath.Rel(s.Root, p)
+    if err != nil { return err }
+    key := filepath.ToSlash(rel)
+    if strings.HasPrefix(key, prefix) {
+      objects = append(objects, StoredObject{Key: key, Size: info.Size(), LastMod: info.ModTime()})
+    }
+    return nil
+  })
+  return objects, err
+}
+
+func (s *FileStore) Delete(key string) (error) {
+  return os.Remove(s.path(key))
+}
@@ -0,0 +1,110 @@
+package lib
+
+import (
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+  "github.com/stretchr/testify/assert"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+func TestFileStoreArchives(t *testing.T) {
+  dir, err := ioutil.TempDir("", "store-test")
+  assert.NoError(t, err)
+  defer os.RemoveAll(dir)
+
+  store, err := NewArchiveStore("file://" + dir, nil)
+  assert.NoError(t, err)
+
+  now := time.Now()
+  key := ArchiveKey("testuser", "testserver", mclib.WorldSnapshot, now, ".zip")
+  // Snapshots in the same millisecond don't overwrite each other.
+  assert.NotEqual(t, key, ArchiveKey("testuser", "testserver", mclib.WorldSnapshot, now, ".zip"))
+  obj, err := store.Put(key, strings.NewReader("archive"))
+  assert.NoError(t, err)
+  assert.Equal(t, int64(7), obj.Size)
+  _, err = store.Put("testuser/not-an-archive.txt", strings.NewReader("other"))
+  assert.NoError(t, err)
+
+  al, err := ListArchives(store, "testuser")
+  assert.NoError(t, err)
+  if assert.Equal(t, 1, len(al)) {
+    assert.Equal(t, "testserver", al[0].ServerName)
+    assert.Equal(t, mclib.WorldSnapshot, al[0].Type)
+    assert.Equal(t, "file://" + dir + "/" + key, al[0].URI)
+  }
+
+  s, k, err := OpenArchiveURI(al[0].URI, nil)
+  assert.NoError(t, err)
+  r, err := s.Get(k)
+  assert.NoError(t, err)
+  b, _ := ioutil.ReadAll(r)
+  r.Close()
+  assert.Equal(t, "archive", string(b))
+
+  assert.NoError(t, store.Delete(key))
+  al, err = ListArchives(store, "testuser")
+  assert.NoError(t, err)
+  assert.Equal(t, 0, len(al))
+}
+
+func TestNewArchiveStore(t *testing.T) {
+  s, err := NewArchiveStore("momentlabs-test", nil)
+  assert.NoError(t, err)
+  assert.Equal(t, "s3://momentlabs-test/a/b.zip", s.URI("a/b.zip"))
+
+  s, err = NewArchiveStore("s3://momentlabs-test/backups/", nil)
+  assert.NoError(t, err)
+  assert.Equal(t, "s3://momentlabs-test/backups/a/b.zip", s.URI("a/b.zip"))
+
+  _, err = NewArchiveStore("ftp://somewhere", nil)
+  assert.Error(t, err)
+}
+
+func TestVerifyArchive(t *testing.T) {
+  dir, err := ioutil.TempDir("", "verify-test")
+  assert.NoError(t, err)
+  defer os.RemoveAll(dir)
+
+  serverDir := filepath.Join(dir, "server")
+  assert.NoError(t, os.MkdirAll(filepath.Join(serverDir, "world"), 0755))
+  assert.NoError(t, os.MkdirAll(filepath.Join(serverDir, "logs"), 0755))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "world", "level.dat"), []byte("level"), 0644))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "logs", "latest.log"), 
+    []byte("[12:00:00] [Server thread/INFO]: Starting minecraft server version 1.11.2\n"), 0644))
+  assert.NoError(t, ioutil.WriteFile(filepath.Join(serverDir, "server.properties"), []byte("level-name=world\n"), 0644))
+
+  store := NewFileStore(filepath.Join(dir, "archives"))
+  s := &mclib.Server{User: "testuser", Name: "testserver", ServerDirectory: serverDir}
+  result, err := TakeSnapshot(s, mclib.ServerSnapshot, store, SnapshotOptions{})
+  assert.NoError(t, err)
+
+  report, err := VerifyArchive(result.URI, nil, nil)
+  if assert.NoError(t, err) {
+    assert.True(t, report.OK(), "%v", report.Problems)
+    assert.Equal(t, 2, report.Checked)
+    assert.Equal(t, "1.11.2", report.Manifest.MinecraftVersion)
+    assert.Equal(t, "world", report.Manifest.LevelName)
+    // Logs are left out by default.
+    for _, mf := range report.Manifest.Files {
+      assert.NotEqual(t, "logs/latest.log", mf.Path)
+    }
+  }
+
+  // Damage the manifest so the archive no longer matches it.
+  m, err := GetManifest(store, ManifestKey(result.Object.Key), nil)
+  assert.NoError(t, err)
+  m.Files[0].SHA256 = "0000"
+  _, err = putManifest(store, ManifestKey(result.Object.Key), m, nil)
+  assert.NoError(t, err)
+  report, err = VerifyArchive(result.URI, nil, nil)
+  if assert.NoError(t, err) {
+    assert.False(t, report.OK())
+    assert.Equal(t, 1, len(report.Problems))
+  }
+}
@@ -0,0 +1,197 @@
+package lib
+
+import(
+  "bytes"
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "fmt"
+  "io"
+  "io/ioutil"
+  "net/http"
+  "net/url"
+  "strings"
+  "sync"
+  "time"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+// Events are POSTed as JSON to each webhook URL:
+//   {"event": "snapshot.failure", "time": ..., "user": ..., "server": ..., "cluster": ..., "data": {...}}
+// With a secret, the X-Craft-Signature header is "sha256=" and the hex
+// HMAC-SHA256 of the body, so receivers can check where it came from.
+const(
+  EventSnapshotSuccess = "snapshot.success"
+  EventSnapshotFailure = "snapshot.failure"
+  EventSnapshotSpooled = "snapshot.spooled" // Taken, but the upload failed and waits in the spool.
+  EventRconLost = "rcon.lost"
+  EventRconRecovered = "rcon.recovered"
+  EventUsersChanged = "users.changed"
+)
+
+var WebhookEvents = []string{EventSnapshotSuccess, EventSnapshotFailure, EventSnapshotSpooled, EventRconLost, EventRconRecovered, EventUsersChanged}
+
+const(
+  WebhookEventHeader = "X-Craft-Event"
+  WebhookSignatureHeader = "X-Craft-Signature"
+  webhookSignaturePrefix = "sha256="
+)
+
+type WebhookEvent struct {
+  Event string `json:"event"`
+  Time time.Time `json:"time"`
+  User string `json:"user"`
+  Server string `json:"server"`
+  Cluster string `json:"cluster"`
+  Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// Sends events to webhooks in the background. A nil Notifier sends nothing.
+type Notifier struct {
+  // Attempts after the first, waiting RetryDelay and doubling it between them.
+  Retries int
+  RetryDelay time.Duration
+  Client *http.Client
+
+  server *mclib.Server
+  urls []string
+  secret []byte
+  events map[string]bool
+  wg sync.WaitGroup
+}
+
+// events limits what's sent, all of them if it's empty. Returns nil if there are no urls.
+func NewNotifier(s *mclib.Server, urls []string, secret string, events []string) (*Notifier, error) {
+  if len(urls) == 0 { return nil, nil }
+  for _, u := range urls {
+    pu, err := url.Parse(u)
+    if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+      return nil, fmt.Errorf("Bad webhook URL \"%s\": use http:// or https://", u)
+    }
+  }
+  n := &Notifier{
+    Retries: 3,
+    RetryDelay: 5 * time.Second,
+    Client: &http.Client{Timeout: 10 * time.Second},
+    server: s,
+    urls: urls,
+    events: make(map[string]bool),
+  }
+  if secret != "" { n.secret = []byte(secret) }
+  for _, e := range events {
+    if !isWebhookEvent(e) {
+      return nil, fmt.Errorf("Unknown webhook event \"%s\", use one of: %s", e, strings.Join(WebhookEvents, ", "))
+    }
+    n.events[e] = true
+  }
+  return n, nil
+}
+
+func isWebhookEvent(e string) (bool) {
+  for _, we := range WebhookEvents {
+    if e == we { return true }
+  }
+  return false
+}
+
+// Would event be sent?
+func (n *Notifier) Wants(event string) (bool) {
+  return n != nil && (len(n.events) == 0 || n.events[event])
+}
+
+// Send event to every webhook without waiting.
+func (n *Notifier) Notify(event string, data map[string]interface{}) {
+  if !n.Wants(event) { return }
+  ev := WebhookEvent{
+    Event: event,
+    Time: time.Now().UTC(),
+    User: n.server.User,
+    Server: n.server.Name,
+    Cluster: n.server.ClusterName,
+    Data: data,
+  }
+  body, err := json.Marshal(ev)
+  if err != nil {
+    f := n.server.LogFields()
+    f["operation"] = "Webhook"
+    f["event"] = event
+    log.Error(f, "Can't encode webhook event.", err)
+    return
+  }
+  for _, u := range n.urls {
+    n.wg.Add(1)
+    go func(u string) {
+      defer n.wg.Done()
+      n.deliver(u, event, body)
+    }(u)
+  }
+}
+
+// Wait up to timeout for notifications on their way. Returns false if some didn't finish.
+func (n *Notifier) Wait(timeout time.Duration) (bool) {
+  if n == nil { return true }
+  done := make(chan struct{})
+  go func() {
+    n.wg.Wait()
+    close(done)
+  }()
+  select {
+  case <- done:
+    return true
+  case <- time.After(timeout):
+    return false
+  }
+}
+
+func (n *Notifier) deliver(u, event string, body []byte) {
+  f := n.server.LogFields()
+  f["operation"] = "Webhook"
+  f["event"] = event
+  f["webhook"] = u
+  delay := n.RetryDelay
+  var err error
+  for attempt := 0; attempt <= n.Retries; attempt++ {
+    if attempt > 0 {
+      time.Sleep(delay)
+      delay *= 2
+    }
+    if err = n.post(u, event, body); err == nil {
+      f["attempts"] = attempt + 1
+      log.Debug(f, "Webhook delivered.")
+      return
+    }
+  }
+  f["attempts"] = n.Retries + 1
+  log.Error(f, "Webhook failed.", err)
+}
+
+func (n *Notifier) post(u, event string, body []byte) (error) {
+  req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+  if err != nil { return err }
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set(WebhookEventHeader, event)
+  if n.secret != nil { req.Header.Set(WebhookSignatureHeader, SignWebhook(n.secret, body)) }
+  resp, err := n.Client.Do(req)
+  if err != nil { return err }
+  defer resp.Body.Close()
+  io.Copy(ioutil.Discard, resp.Body)
+  if resp.StatusCode < 200 || resp.StatusCode > 299 {
+    return fmt.Errorf("Webhook returned %s", resp.Status)
+  }
+  return nil
+}
+
+// The X-Craft-Signature value for body.
+func SignWebhook(secret, body []byte) (string) {
+  mac := hmac.New(sha256.New, secret)
+  mac.Write(body)
+  return webhookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// For receivers: is signature right for body?
+func VerifyWebhook(secret, body []byte, signature string) (bool) {
+  return hmac.Equal([]byte(SignWebhook(secret, body)), []byte(signature))
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+  "encoding/json"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "sync"
+  "testing"
+  "time"
+  "github.com/stretchr/testify/assert"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+func TestNotifier(t *testing.T) {
+  var mu sync.Mutex
+  calls := 0
+  received := make([]WebhookEvent, 0)
+  secret := []byte("sekrit")
+  receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    mu.Lock()
+    defer mu.Unlock()
+    calls++
+    // Fail the first delivery so it's retried.
+    if calls == 1 {
+      w.WriteHeader(http.StatusInternalServerError)
+      return
+    }
+    body, _ := ioutil.ReadAll(r.Body)
+    if !VerifyWebhook(secret, body, r.Header.Get(WebhookSignatureHeader)) {
+      w.WriteHeader(http.StatusUnauthorized)
+      return
+    }
+    ev := WebhookEvent{}
+    json.Unmarshal(body, &ev)
+    assert.Equal(t, ev.Event, r.Header.Get(WebhookEventHeader))
+    received = append(received, ev)
+  }))
+  defer receiver.Close()
+
+  s := &mclib.Server{User: "testuser", Name: "testserver", ClusterName: "testcluster"}
+  _, err := NewNotifier(s, []string{receiver.URL}, "", []string{"snapshot.bogus"})
+  assert.Error(t, err)
+  _, err = NewNotifier(s, []string{"ftp://example.com"}, "", nil)
+  assert.Error(t, err)
+  n, err := NewNotifier(s, nil, "", nil)
+  assert.NoError(t, err)
+  assert.Nil(t, n)
+  n.Notify(EventSnapshotFailure, nil)
+
+  n, err = NewNotifier(s, []string{receiver.URL}, string(secret), []string{EventSnapshotFailure, EventRconLost})
+  assert.NoError(t, err)
+  n.RetryDelay = time.Millisecond
+  n.Notify(EventSnapshotSuccess, nil)
+  n.Notify(EventSnapshotFailure, map[string]interface{}{"snapshotType": mclib.WorldSnapshot.String(), "error": "Disk full."})
+  assert.True(t, n.Wait(5 * time.Second))
+
+  mu.Lock()
+  defer mu.Unlock()
+  assert.Equal(t, 2, calls)
+  if assert.Len(t, received, 1) {
+    ev := received[0]
+    assert.Equal(t, EventSnapshotFailure, ev.Event)
+    assert.Equal(t, "testserver", ev.Server)
+    assert.Equal(t, "testcluster", ev.Cluster)
+    assert.Equal(t, "Disk full.", ev.Data["error"])
+  }
+}
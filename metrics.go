@@ -0,0 +1,103 @@
+package main
+
+import(
+  "net/http"
+  "time"
+  "github.com/prometheus/client_golang/prometheus"
+  "github.com/prometheus/client_golang/prometheus/promhttp"
+
+  // "mclib"
+  "github.com/jdrivas/mclib"
+)
+
+// Prometheus metrics, served on /metrics alongside /healthz and /status.
+// Every metric carries the server's user, name and cluster as labels.
+type controllerMetrics struct {
+  registry *prometheus.Registry
+  snapshotDuration *prometheus.HistogramVec
+  snapshotSize *prometheus.GaugeVec
+  snapshots *prometheus.CounterVec
+  lastSuccess *prometheus.GaugeVec
+  users prometheus.Gauge
+  rconReconnects prometheus.Counter
+  uploadBytes *prometheus.CounterVec
+  uploadRate *prometheus.GaugeVec
+}
+
+var metrics *controllerMetrics
+
+func newControllerMetrics(s *mclib.Server) (*controllerMetrics) {
+  labels := prometheus.Labels{"user": s.User, "name": s.Name, "cluster": s.ClusterName}
+  m := &controllerMetrics{
+    registry: prometheus.NewRegistry(),
+    snapshotDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+      Name: "craft_snapshot_duration_seconds",
+      Help: "Time taken to archive and upload a snapshot.",
+      Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+    }, []string{"type"}),
+    snapshotSize: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+      Name: "craft_snapshot_size_bytes",
+      Help: "Bytes of the last snapshot stored, only the new blobs of incremental ones.",
+    }, []string{"type"}),
+    snapshots: prometheus.NewCounterVec(prometheus.CounterOpts{
+      Name: "craft_snapshots_total",
+      Help: "Snapshots taken, by result: Success, Spooled or Failure.",
+    }, []string{"type", "result"}),
+    lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+      Name: "craft_snapshot_last_success_timestamp_seconds",
+      Help: "Unix time of the last successful snapshot.",
+    }, []string{"type"}),
+    users: prometheus.NewGauge(prometheus.GaugeOpts{
+      Name: "craft_users",
+      Help: "Users on the server at the last check.",
+    }),
+    rconReconnects: prometheus.NewCounter(prometheus.CounterOpts{
+      Name: "craft_rcon_reconnects_total",
+      Help: "Times the RCON connection was remade after a failure.",
+    }),
+    uploadBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+      Name: "craft_upload_bytes_total",
+      Help: "Snapshot bytes stored.",
+    }, []string{"type"}),
+    uploadRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+      Name: "craft_upload_bytes_per_second",
+      Help: "Throughput of the last snapshot upload.",
+    }, []string{"type"}),
+  }
+  reg := prometheus.WrapRegistererWith(labels, m.registry)
+  reg.MustRegister(m.snapshotDuration, m.snapshotSize, m.snapshots, m.lastSuccess,
+    m.users, m.rconReconnects, m.uploadBytes, m.uploadRate)
+  return m
+}
+
+// The recording methods do nothing on a nil *controllerMetrics, so callers
+// needn't care whether metrics are being served.
+
+// size, the bytes uploaded, is ignored for failures.
+func (m *controllerMetrics) snapshotDone(aType mclib.ArchiveType, result string, size int64, elapsed time.Duration) {
+  if m == nil { return }
+  t := aType.String()
+  m.snapshotDuration.WithLabelValues(t).Observe(elapsed.Seconds())
+  m.snapshots.WithLabelValues(t, result).Inc()
+  if result == "Failure" { return }
+  m.snapshotSize.WithLabelValues(t).Set(float64(size))
+  // Spooled isn't stored yet.
+  if result != "Success" { return }
+  m.lastSuccess.WithLabelValues(t).Set(float64(time.Now().Unix()))
+  m.uploadBytes.WithLabelValues(t).Add(float64(size))
+  if elapsed > 0 { m.uploadRate.WithLabelValues(t).Set(float64(size) / elapsed.Seconds()) }
+}
+
+func (m *controllerMetrics) usersChecked(users int) {
+  if m == nil { return }
+  m.users.Set(float64(users))
+}
+
+func (m *controllerMetrics) rconReconnected() {
+  if m == nil { return }
+  m.rconReconnects.Inc()
+}
+
+func (m *controllerMetrics) handler() (http.Handler) {
+  return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}